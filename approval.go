@@ -0,0 +1,304 @@
+package qsgpm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultApprovalDir is the pending directory if the dir of the approval section is omitted.
+const defaultApprovalDir = ".qsgpm/pending"
+
+// ApprovalConfig is the thresholds of the plans which need an approval before applied.
+// A threshold is the maximum number of the changes applied without an approval, unlimited if omitted.
+type ApprovalConfig struct {
+	// Dir is the directory of the pending plans.
+	Dir                        string `yaml:"dir"`
+	MaxChanges                 *int   `yaml:"max_changes"`
+	MaxMembershipRemovals      *int   `yaml:"max_membership_removals"`
+	MaxGroupDeletions          *int   `yaml:"max_group_deletions"`
+	MaxCustomPermissionChanges *int   `yaml:"max_custom_permission_changes"`
+}
+
+func (cfg *ApprovalConfig) Restrict() error {
+	if cfg.Dir == "" {
+		cfg.Dir = defaultApprovalDir
+	}
+	errs := make([]error, 0)
+	for key, threshold := range map[string]*int{
+		"max_changes":                   cfg.MaxChanges,
+		"max_membership_removals":       cfg.MaxMembershipRemovals,
+		"max_group_deletions":           cfg.MaxGroupDeletions,
+		"max_custom_permission_changes": cfg.MaxCustomPermissionChanges,
+	} {
+		if threshold != nil && *threshold < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", key))
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
+
+// Exceeded returns the reasons why the plan needs an approval, empty if it can be applied.
+func (cfg *ApprovalConfig) Exceeded(plan *Plan) []string {
+	counts := make(map[ChangeType]int)
+	for _, c := range plan.Changes {
+		counts[c.Type]++
+	}
+	reasons := make([]string, 0)
+	check := func(threshold *int, n int, what string) {
+		if threshold != nil && n > *threshold {
+			reasons = append(reasons, fmt.Sprintf("%d %s > %d", n, what, *threshold))
+		}
+	}
+	check(cfg.MaxChanges, len(plan.Changes), "changes")
+	check(cfg.MaxMembershipRemovals, counts[ChangeRemoveMembership], "membership removals")
+	check(cfg.MaxGroupDeletions, counts[ChangeDeleteGroup], "group deletions")
+	check(cfg.MaxCustomPermissionChanges, counts[ChangeUpdateCustomPermission], "custom permission changes")
+	return reasons
+}
+
+// PendingPlan is a plan waiting for an approval.
+type PendingPlan struct {
+	ID         string    `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ConfigHash string    `json:"config_hash,omitempty"`
+	// RequestedBy is who ran the plan, who can not approve it.
+	RequestedBy string `json:"requested_by,omitempty"`
+	// Namespaces and Users are the scope of the run which planned it.
+	Namespaces []string `json:"namespaces,omitempty"`
+	Users      []string `json:"users,omitempty"`
+	Reasons    []string `json:"reasons"`
+	Plan       *Plan    `json:"plan"`
+	// ApprovedBy and ApprovedAt are set when the plan is applied by the approval.
+	ApprovedBy string     `json:"approved_by,omitempty"`
+	ApprovedAt *time.Time `json:"approved_at,omitempty"`
+}
+
+func (p *PendingPlan) String() string {
+	return fmt.Sprintf("%s: %d change(s), %s, requested by %s", p.ID, len(p.Plan.Changes), strings.Join(p.Reasons, ", "), p.RequestedBy)
+}
+
+// newPendingPlan returns the pending plan, whose ID is the hash of the scope and the changes,
+// so the runs planning the same changes share the pending plan.
+func newPendingPlan(cfg *Config, opt RunOption, plan *Plan, reasons []string) (*PendingPlan, error) {
+	bs, err := json.Marshal(struct {
+		Namespaces []string `json:"namespaces"`
+		Users      []string `json:"users"`
+		Changes    []string `json:"changes"`
+	}{
+		Namespaces: opt.Namespaces,
+		Users:      opt.Users,
+		Changes:    changeKeys(plan),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(bs)
+	return &PendingPlan{
+		ID:          fmt.Sprintf("%x", sum[:8]),
+		CreatedAt:   time.Now(),
+		ConfigHash:  cfg.Hash(),
+		RequestedBy: opt.RequestedBy,
+		Namespaces:  opt.Namespaces,
+		Users:       opt.Users,
+		Reasons:     reasons,
+		Plan:        plan,
+	}, nil
+}
+
+// pendingPlanPath returns the path of the pending plan, rejecting the IDs which escape the directory.
+func pendingPlanPath(dir, id string) (string, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return "", fmt.Errorf("invalid plan id %q", id)
+	}
+	return filepath.Join(dir, id+".json"), nil
+}
+
+func (p *PendingPlan) write(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	bs, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(bs, '\n'), 0640)
+}
+
+// LoadPendingPlan loads the pending plan of the ID in the directory.
+func LoadPendingPlan(dir, id string) (*PendingPlan, error) {
+	path, err := pendingPlanPath(dir, id)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("plan %s is not pending in %s", id, dir)
+		}
+		return nil, err
+	}
+	var p PendingPlan
+	if err := json.Unmarshal(bs, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if p.Plan == nil {
+		return nil, fmt.Errorf("%s: no plan", path)
+	}
+	return &p, nil
+}
+
+// ListPendingPlans returns the pending plans in the directory, in the order of the creation.
+func ListPendingPlans(dir string) ([]*PendingPlan, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	plans := make([]*PendingPlan, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		p, err := LoadPendingPlan(dir, strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, p)
+	}
+	sort.SliceStable(plans, func(i, j int) bool {
+		return plans[i].CreatedAt.Before(plans[j].CreatedAt)
+	})
+	return plans, nil
+}
+
+// ApprovalRequiredError is returned by a run whose plan exceeds the thresholds of the approval section.
+// No changes are applied, and the plan is written to the pending directory.
+type ApprovalRequiredError struct {
+	PlanID  string
+	Path    string
+	Reasons []string
+}
+
+func (e *ApprovalRequiredError) Error() string {
+	return fmt.Sprintf("approval required for plan %s (%s), written to %s: run qsgpm approve %s to apply it",
+		e.PlanID, strings.Join(e.Reasons, ", "), e.Path, e.PlanID)
+}
+
+// approvalGate returns the gate which pends the plan exceeding the thresholds, nil if no approval is needed.
+func (app *App) approvalGate(opt RunOption) func(*Plan) error {
+	approval := app.cfg.Approval
+	if approval == nil || opt.DryRun {
+		return nil
+	}
+	return func(plan *Plan) error {
+		reasons := approval.Exceeded(plan)
+		if len(reasons) == 0 {
+			return nil
+		}
+		pending, err := newPendingPlan(app.cfg, opt, plan, reasons)
+		if err != nil {
+			return err
+		}
+		path, err := pendingPlanPath(approval.Dir, pending.ID)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err == nil {
+			log.Printf("[info] plan %s is already pending", pending.ID)
+		} else if err := pending.write(path); err != nil {
+			return fmt.Errorf("failed to write the pending plan: %w", err)
+		}
+		return &ApprovalRequiredError{
+			PlanID:  pending.ID,
+			Path:    path,
+			Reasons: reasons,
+		}
+	}
+}
+
+// sameChanges reports whether the plans have the same changes, regardless of the order.
+func sameChanges(a, b *Plan) bool {
+	if len(a.Changes) != len(b.Changes) {
+		return false
+	}
+	ka, kb := changeKeys(a), changeKeys(b)
+	for i := range ka {
+		if ka[i] != kb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// changeKeys returns the sorted JSON of the changes of the plan.
+func changeKeys(p *Plan) []string {
+	ks := make([]string, 0, len(p.Changes))
+	for _, c := range p.Changes {
+		bs, _ := json.Marshal(c)
+		ks = append(ks, string(bs))
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// Approve applies the pending plan of the ID, and moves it to the approved directory under the pending directory.
+// The approver must be another person than the requester of the plan.
+// The current state is planned again with the scope of the pending plan, and the plan is rejected as stale
+// if the changes differ, because the state or the config has changed since it was planned.
+func (app *App) Approve(ctx context.Context, id string, approver string, opt RunOption) (*RunSummary, error) {
+	if app.cfg.Approval == nil {
+		return nil, errors.New("no approval section in the config")
+	}
+	if approver == "" {
+		return nil, errors.New("approver is required")
+	}
+	dir := app.cfg.Approval.Dir
+	pending, err := LoadPendingPlan(dir, id)
+	if err != nil {
+		return nil, err
+	}
+	if pending.RequestedBy == "" {
+		return nil, fmt.Errorf("plan %s has no requester, run apply again", id)
+	}
+	if strings.EqualFold(pending.RequestedBy, approver) {
+		return nil, fmt.Errorf("plan %s is requested by %s, and must be approved by another person", id, approver)
+	}
+	log.Printf("[info] approving plan %s", pending)
+	opt.Namespaces = pending.Namespaces
+	opt.Users = pending.Users
+	opt.gate = func(plan *Plan) error {
+		if !sameChanges(pending.Plan, plan) {
+			return fmt.Errorf("plan %s is stale: the current plan has %d change(s) instead of %d, run apply again", id, len(plan.Changes), len(pending.Plan.Changes))
+		}
+		return nil
+	}
+	summary, err := app.RunWithSummary(ctx, opt)
+	if err != nil || opt.DryRun {
+		return summary, err
+	}
+	now := time.Now()
+	pending.ApprovedBy = approver
+	pending.ApprovedAt = &now
+	path, _ := pendingPlanPath(dir, id)
+	approvedPath, _ := pendingPlanPath(filepath.Join(dir, "approved"), id)
+	if err := pending.write(approvedPath); err != nil {
+		return summary, fmt.Errorf("plan %s is applied, but failed to archive it: %w", id, err)
+	}
+	if err := os.Remove(path); err != nil {
+		return summary, fmt.Errorf("plan %s is applied, but failed to remove it: %w", id, err)
+	}
+	log.Printf("[info] plan %s approved by %s, archived to %s", id, approver, approvedPath)
+	return summary, nil
+}
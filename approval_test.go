@@ -0,0 +1,146 @@
+package qsgpm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+// newApprovalConfig writes the config including testdata/config.yaml with the approval section.
+func newApprovalConfig(t *testing.T) (string, string) {
+	t.Helper()
+	base, err := filepath.Abs("testdata/config.yaml")
+	require.NoError(t, err)
+	dir := t.TempDir()
+	pendingDir := filepath.Join(dir, "pending")
+	configPath := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(`include: %q
+approval:
+  dir: %q
+  max_group_deletions: 0
+  max_membership_removals: 5
+`, base, pendingDir)), 0644))
+	return configPath, pendingDir
+}
+
+func mutatingCalls(calls []string) []string {
+	mutating := make([]string, 0)
+	for _, call := range calls {
+		if !strings.HasPrefix(call, "List") && !strings.HasPrefix(call, "Describe") {
+			mutating = append(mutating, call)
+		}
+	}
+	return mutating
+}
+
+func TestAppRunApprovalRequired(t *testing.T) {
+	configPath, pendingDir := newApprovalConfig(t)
+	app, fake := newTestApp(t, configPath, "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+	)
+	ctx := context.Background()
+
+	summary, err := app.RunWithSummary(ctx, qsgpm.RunOption{DryRun: true})
+	require.NoError(t, err, "dry run needs no approval")
+	require.Equal(t, 1, summary.GroupsDeleted)
+
+	_, err = app.RunWithSummary(ctx, qsgpm.RunOption{RequestedBy: "bob"})
+	var approvalErr *qsgpm.ApprovalRequiredError
+	require.True(t, errors.As(err, &approvalErr), "%v", err)
+	require.Equal(t, []string{"1 group deletions > 0"}, approvalErr.Reasons)
+	require.Empty(t, mutatingCalls(fake.calls), "no changes are applied")
+
+	plans, err := qsgpm.ListPendingPlans(pendingDir)
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+	require.Equal(t, approvalErr.PlanID, plans[0].ID)
+	require.Len(t, plans[0].Plan.Changes, 12)
+
+	_, err = app.Approve(ctx, "../config", "alice", qsgpm.RunOption{})
+	require.EqualError(t, err, `invalid plan id "../config"`)
+
+	summary, err = app.Approve(ctx, approvalErr.PlanID, "alice", qsgpm.RunOption{})
+	require.NoError(t, err)
+	require.Equal(t, 1, summary.GroupsDeleted)
+	require.Equal(t, 2, summary.MembershipsRemoved)
+	require.Len(t, mutatingCalls(fake.calls), 12)
+
+	plans, err = qsgpm.ListPendingPlans(pendingDir)
+	require.NoError(t, err)
+	require.Empty(t, plans)
+	approved, err := qsgpm.LoadPendingPlan(filepath.Join(pendingDir, "approved"), approvalErr.PlanID)
+	require.NoError(t, err)
+	require.Equal(t, "alice", approved.ApprovedBy)
+	require.NotNil(t, approved.ApprovedAt)
+
+	_, err = app.Approve(ctx, approvalErr.PlanID, "alice", qsgpm.RunOption{})
+	require.ErrorContains(t, err, "is not pending")
+}
+
+func TestAppApproveStale(t *testing.T) {
+	configPath, pendingDir := newApprovalConfig(t)
+	app, fake := newTestApp(t, configPath, "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+	)
+	ctx := context.Background()
+	_, err := app.RunWithSummary(ctx, qsgpm.RunOption{RequestedBy: "bob"})
+	var approvalErr *qsgpm.ApprovalRequiredError
+	require.True(t, errors.As(err, &approvalErr), "%v", err)
+
+	// the group is deleted by someone else after planned.
+	delete(fake.groups["default"], "legacy")
+	_, err = app.Approve(ctx, approvalErr.PlanID, "alice", qsgpm.RunOption{})
+	require.ErrorContains(t, err, fmt.Sprintf("plan %s is stale", approvalErr.PlanID))
+	require.Empty(t, mutatingCalls(fake.calls))
+
+	plans, err := qsgpm.ListPendingPlans(pendingDir)
+	require.NoError(t, err)
+	require.Len(t, plans, 1, "the stale plan is kept")
+}
+
+func TestAppRunApprovalRequiredTwice(t *testing.T) {
+	configPath, pendingDir := newApprovalConfig(t)
+	app, _ := newTestApp(t, configPath, "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+	)
+	ctx := context.Background()
+	var first, second *qsgpm.ApprovalRequiredError
+	_, err := app.RunWithSummary(ctx, qsgpm.RunOption{RequestedBy: "bob"})
+	require.True(t, errors.As(err, &first), "%v", err)
+	_, err = app.RunWithSummary(ctx, qsgpm.RunOption{RequestedBy: "bob"})
+	require.True(t, errors.As(err, &second), "%v", err)
+	require.Equal(t, first.PlanID, second.PlanID, "the same changes share the pending plan")
+
+	plans, err := qsgpm.ListPendingPlans(pendingDir)
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+}
+
+func TestAppApproveSelf(t *testing.T) {
+	configPath, pendingDir := newApprovalConfig(t)
+	app, fake := newTestApp(t, configPath, "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+	)
+	ctx := context.Background()
+	_, err := app.RunWithSummary(ctx, qsgpm.RunOption{RequestedBy: "alice"})
+	var approvalErr *qsgpm.ApprovalRequiredError
+	require.True(t, errors.As(err, &approvalErr), "%v", err)
+
+	plans, err := qsgpm.ListPendingPlans(pendingDir)
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+	require.Equal(t, "alice", plans[0].RequestedBy)
+
+	_, err = app.Approve(ctx, approvalErr.PlanID, "alice", qsgpm.RunOption{})
+	require.EqualError(t, err, fmt.Sprintf("plan %s is requested by alice, and must be approved by another person", approvalErr.PlanID))
+	_, err = app.Approve(ctx, approvalErr.PlanID, "", qsgpm.RunOption{})
+	require.EqualError(t, err, "approver is required")
+	require.Empty(t, mutatingCalls(fake.calls), "no changes are applied")
+}
@@ -0,0 +1,204 @@
+package qsgpm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditConfig is the configuration of the audit log, which records every change applied to QuickSight.
+type AuditConfig struct {
+	Sinks []*AuditSinkConfig `yaml:"sinks"`
+	// Actor is who runs qsgpm, such as the user who merged the config change.
+	Actor       string `yaml:"actor"`
+	GitRevision string `yaml:"git_revision"`
+}
+
+func (cfg *AuditConfig) Restrict() error {
+	errs := make([]error, 0)
+	for i, sink := range cfg.Sinks {
+		if err := sink.Restrict(); err != nil {
+			errs = append(errs, wrapConfigError(fmt.Sprintf("sinks[%d]", i), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AuditSinkConfig is a built-in destination of the audit records.
+type AuditSinkConfig struct {
+	// Type is file or stdout.
+	Type string `yaml:"type"`
+	// Path is the file path for the file sink, to which the records are appended as JSON lines.
+	Path string `yaml:"path"`
+}
+
+func (cfg *AuditSinkConfig) Restrict() error {
+	switch cfg.Type {
+	case "file":
+		if cfg.Path == "" {
+			return errors.New("path is required for the file sink")
+		}
+	case "stdout":
+		if cfg.Path != "" {
+			return errors.New("path is not allowed for the stdout sink")
+		}
+	case "":
+		return errors.New("type is required")
+	default:
+		return fmt.Errorf("type %s is not one of file or stdout", cfg.Type)
+	}
+	return nil
+}
+
+// NewSink opens the sink.
+func (cfg *AuditSinkConfig) NewSink() (AuditSink, error) {
+	switch cfg.Type {
+	case "file":
+		return NewFileAuditSink(cfg.Path)
+	case "stdout":
+		return NewWriterAuditSink(os.Stdout), nil
+	}
+	return nil, fmt.Errorf("unknown audit sink type %s", cfg.Type)
+}
+
+// AuditRecord is the record of a change applied to QuickSight: who, what and when.
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// Actor is the configured actor, and Principal is the ARN of the AWS principal which called the API.
+	Actor     string     `json:"actor,omitempty"`
+	Principal string     `json:"principal,omitempty"`
+	AccountID string     `json:"account_id"`
+	Target    string     `json:"target,omitempty"`
+	Namespace string     `json:"namespace"`
+	Action    ChangeType `json:"action"`
+	UserName  string     `json:"user_name,omitempty"`
+	GroupName string     `json:"group_name,omitempty"`
+	Rule      string     `json:"rule,omitempty"`
+	// Reason is the reason of the override which caused the change, if any.
+	Reason string `json:"reason,omitempty"`
+	// Before and After are the custom permission names. nil means no custom permission.
+	Before      *string `json:"before,omitempty"`
+	After       *string `json:"after,omitempty"`
+	RequestID   string  `json:"request_id,omitempty"`
+	ConfigHash  string  `json:"config_hash,omitempty"`
+	GitRevision string  `json:"git_revision,omitempty"`
+	// Error is the error of the API call, empty if succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// AuditSink is a destination of the audit records.
+// Write must return an error if the record is not persisted, and then no more changes are applied.
+type AuditSink interface {
+	Write(ctx context.Context, record *AuditRecord) error
+	Close() error
+}
+
+type writerAuditSink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// NewWriterAuditSink returns the sink which writes the records to w as JSON lines.
+func NewWriterAuditSink(w io.Writer) AuditSink {
+	return &writerAuditSink{
+		w:   w,
+		enc: json.NewEncoder(w),
+	}
+}
+
+func (s *writerAuditSink) Write(ctx context.Context, record *AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(record)
+}
+
+func (s *writerAuditSink) Close() error {
+	return nil
+}
+
+type fileAuditSink struct {
+	writerAuditSink
+	f *os.File
+}
+
+// NewFileAuditSink returns the sink which appends the records to the file as JSON lines.
+// Each record is synced to the disk before the next change is applied.
+func NewFileAuditSink(path string) (AuditSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("audit sink: %w", err)
+	}
+	return &fileAuditSink{
+		writerAuditSink: writerAuditSink{
+			w:   f,
+			enc: json.NewEncoder(f),
+		},
+		f: f,
+	}, nil
+}
+
+func (s *fileAuditSink) Write(ctx context.Context, record *AuditRecord) error {
+	if err := s.writerAuditSink.Write(ctx, record); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *fileAuditSink) Close() error {
+	return s.f.Close()
+}
+
+// auditor writes the audit records of the applied changes to the sinks.
+type auditor struct {
+	sinks       []AuditSink
+	actor       string
+	configHash  string
+	gitRevision string
+}
+
+func (a *auditor) record(ctx context.Context, e *ChangeEvent, principal string) error {
+	r := &AuditRecord{
+		Time:        e.Time,
+		Actor:       a.actor,
+		Principal:   principal,
+		AccountID:   e.AccountID,
+		Target:      e.Target,
+		Namespace:   e.Namespace,
+		Action:      e.Type,
+		UserName:    e.UserName,
+		GroupName:   e.GroupName,
+		Rule:        e.Rule,
+		Reason:      e.Reason,
+		Before:      e.Before,
+		After:       e.After,
+		RequestID:   e.RequestID,
+		ConfigHash:  a.configHash,
+		GitRevision: a.gitRevision,
+	}
+	if e.Error != nil {
+		r.Error = e.Error.Error()
+	}
+	errs := make([]error, 0)
+	for _, sink := range a.sinks {
+		if err := sink.Write(ctx, r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (a *auditor) Close() error {
+	errs := make([]error, 0, len(a.sinks))
+	for _, sink := range a.sinks {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,109 @@
+package qsgpm_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppRunAudit(t *testing.T) {
+	var buf bytes.Buffer
+	app, fake := newTestApp(t, "testdata/config_audit.yaml", "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+		qsgpm.WithAuditSink(qsgpm.NewWriterAuditSink(&buf)),
+	)
+	defer app.Close()
+	ctx := context.Background()
+
+	require.NoError(t, app.Run(ctx, qsgpm.RunOption{DryRun: true}))
+	require.Empty(t, buf.String(), "dry run is not audited")
+
+	require.NoError(t, app.Run(ctx, qsgpm.RunOption{}))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 12)
+	records := make([]*qsgpm.AuditRecord, 0, len(lines))
+	for _, line := range lines {
+		var r qsgpm.AuditRecord
+		require.NoError(t, json.Unmarshal([]byte(line), &r))
+		require.Equal(t, "alice", r.Actor)
+		require.Equal(t, "0123456789abcdef", r.GitRevision)
+		require.Regexp(t, `^sha256:[0-9a-f]{64}$`, r.ConfigHash)
+		require.Equal(t, "123456789012", r.AccountID)
+		require.NotEmpty(t, r.RequestID)
+		records = append(records, &r)
+	}
+	require.Equal(t, qsgpm.ChangeUpdateCustomPermission, records[0].Action)
+	require.Equal(t, "Analyst/piyo@example.com", records[0].UserName)
+	require.Nil(t, records[0].Before)
+	require.Equal(t, "analysis", *records[0].After)
+	require.Equal(t, "rules[2]", records[0].Rule)
+	require.Len(t, fake.calls, 12)
+}
+
+func TestAppRunAuditFailed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := qsgpm.NewFileAuditSink(path)
+	require.NoError(t, err)
+	app, fake := newTestApp(t, "testdata/config_audit.yaml", "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+		qsgpm.WithAuditSink(sink),
+	)
+	fake.failOn = "CreateGroup"
+	require.Error(t, app.Run(context.Background(), qsgpm.RunOption{}))
+	require.NoError(t, app.Close())
+
+	bs, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(bs)), "\n")
+	require.Len(t, lines, 3, "the failed change is also audited")
+	var r qsgpm.AuditRecord
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &r))
+	require.Equal(t, qsgpm.ChangeCreateGroup, r.Action)
+	require.Equal(t, "CreateGroup failed", r.Error)
+
+	// a closed sink fails to write, and no more changes are applied.
+	app, fake = newTestApp(t, "testdata/config_audit.yaml", "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+		qsgpm.WithAuditSink(sink),
+	)
+	err = app.Run(context.Background(), qsgpm.RunOption{})
+	require.ErrorContains(t, err, "audit: ")
+	require.Len(t, fake.calls, 1)
+}
+
+func TestAppReadOnlyNoAudit(t *testing.T) {
+	base, err := filepath.Abs("testdata/config.yaml")
+	require.NoError(t, err)
+	dir := t.TempDir()
+	auditPath := filepath.Join(dir, "audit.jsonl")
+	configPath := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(`include: %q
+audit:
+  sinks:
+    - type: file
+      path: %q
+`, base, auditPath)), 0644))
+	app, fake := newTestApp(t, configPath, "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+		qsgpm.WithReadOnly(),
+	)
+	defer app.Close()
+	ctx := context.Background()
+
+	plan, err := app.Plan(ctx)
+	require.NoError(t, err)
+	require.Len(t, plan.Changes, 12)
+	require.NoError(t, app.Run(ctx, qsgpm.RunOption{DryRun: true}))
+	require.EqualError(t, app.Run(ctx, qsgpm.RunOption{}), "the read-only app can not apply changes, only dry run")
+	require.Empty(t, mutatingCalls(fake.calls))
+	_, err = os.Stat(auditPath)
+	require.ErrorIs(t, err, os.ErrNotExist, "the audit file is not created")
+}
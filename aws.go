@@ -3,10 +3,14 @@ package qsgpm
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/quicksight"
 	"github.com/aws/aws-sdk-go-v2/service/quicksight/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
@@ -15,9 +19,12 @@ import (
 
 type QuickSightClient interface {
 	ListUsers(context.Context, *quicksight.ListUsersInput, ...func(*quicksight.Options)) (*quicksight.ListUsersOutput, error)
+	DescribeUser(ctx context.Context, params *quicksight.DescribeUserInput, optFns ...func(*quicksight.Options)) (*quicksight.DescribeUserOutput, error)
+	ListUserGroups(ctx context.Context, params *quicksight.ListUserGroupsInput, optFns ...func(*quicksight.Options)) (*quicksight.ListUserGroupsOutput, error)
 	UpdateUser(ctx context.Context, params *quicksight.UpdateUserInput, optFns ...func(*quicksight.Options)) (*quicksight.UpdateUserOutput, error)
 
 	ListGroups(ctx context.Context, params *quicksight.ListGroupsInput, optFns ...func(*quicksight.Options)) (*quicksight.ListGroupsOutput, error)
+	DescribeGroup(ctx context.Context, params *quicksight.DescribeGroupInput, optFns ...func(*quicksight.Options)) (*quicksight.DescribeGroupOutput, error)
 	CreateGroup(ctx context.Context, params *quicksight.CreateGroupInput, optFns ...func(*quicksight.Options)) (*quicksight.CreateGroupOutput, error)
 	DeleteGroup(ctx context.Context, params *quicksight.DeleteGroupInput, optFns ...func(*quicksight.Options)) (*quicksight.DeleteGroupOutput, error)
 
@@ -96,30 +103,87 @@ func (c QuickSightDryRunClient) DeleteGroupMembership(ctx context.Context, param
 type QuickSightService struct {
 	awsAccountID string
 	client       QuickSightClient
+	dryRun       bool
+
+	// logChange logs the applied changes, LogChangeEvent if nil.
+	logChange           ChangeEventHandler
+	changeEventHandlers []ChangeEventHandler
+
+	// auditor records the applied changes, except in dry run. principal is the ARN of the caller.
+	auditor   *auditor
+	principal string
 }
 
-func getCallerAccountID(ctx context.Context, awsCfg aws.Config) (string, error) {
+// getCallerIdentity returns the account ID and the ARN of the caller.
+func getCallerIdentity(ctx context.Context, awsCfg aws.Config) (string, string, error) {
 	client := sts.NewFromConfig(awsCfg)
 	output, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-	return *output.Account, nil
+	return *output.Account, aws.ToString(output.Arn), nil
 }
 
-func newQuickSightService(ctx context.Context) (*QuickSightService, error) {
-	awsCfg, err := config.LoadDefaultConfig(ctx)
+func loadAWSConfig(ctx context.Context, awsCfg *AWSConfig, target *TargetConfig) (aws.Config, error) {
+	optFns := make([]func(*config.LoadOptions) error, 0, 2)
+	if region := coalesceString(target.Region, awsCfg.Region); region != "" {
+		optFns = append(optFns, config.WithRegion(region))
+	}
+	if awsCfg.Profile != "" {
+		optFns = append(optFns, config.WithSharedConfigProfile(awsCfg.Profile))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
 	if err != nil {
-		return nil, err
+		return aws.Config{}, err
+	}
+	if awsCfg.AssumeRoleARN != "" {
+		cfg.Credentials = newAssumeRoleCredentials(cfg, awsCfg.AssumeRoleARN, awsCfg.ExternalID)
 	}
-	awsAccountID, err := getCallerAccountID(ctx, awsCfg)
+	if target.RoleARN != "" {
+		cfg.Credentials = newAssumeRoleCredentials(cfg, target.RoleARN, target.ExternalID)
+	}
+	return cfg, nil
+}
+
+func newAssumeRoleCredentials(awsCfg aws.Config, roleARN string, externalID string) aws.CredentialsProvider {
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), roleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = "qsgpm"
+		if externalID != "" {
+			o.ExternalID = aws.String(externalID)
+		}
+	})
+	return aws.NewCredentialsCache(provider)
+}
+
+// newQuickSightService returns the service for the target.
+// If identify is true, the caller identity is resolved even if the account ID is known.
+func newQuickSightService(ctx context.Context, awsCfg *AWSConfig, target *TargetConfig, identify bool) (*QuickSightService, error) {
+	cfg, err := loadAWSConfig(ctx, awsCfg, target)
 	if err != nil {
 		return nil, err
 	}
-	client := quicksight.NewFromConfig(awsCfg)
+	awsAccountID := target.AccountID
+	if awsAccountID == "" && target.RoleARN == "" {
+		awsAccountID = awsCfg.AccountID
+	}
+	var principal string
+	if awsAccountID == "" || identify {
+		var callerAccountID string
+		callerAccountID, principal, err = getCallerIdentity(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		awsAccountID = coalesceString(awsAccountID, callerAccountID)
+	}
+	client := quicksight.NewFromConfig(cfg, func(o *quicksight.Options) {
+		if awsCfg.Endpoint != "" {
+			o.EndpointResolver = quicksight.EndpointResolverFromURL(awsCfg.Endpoint)
+		}
+	})
 	return &QuickSightService{
 		awsAccountID: awsAccountID,
 		client:       client,
+		principal:    principal,
 	}, nil
 }
 
@@ -129,6 +193,10 @@ func (svc QuickSightService) GetDryRunService() *QuickSightService {
 		client: QuickSightDryRunClient{
 			QuickSightClient: svc.client,
 		},
+		dryRun:              true,
+		logChange:           svc.logChange,
+		changeEventHandlers: svc.changeEventHandlers,
+		principal:           svc.principal,
 	}
 }
 
@@ -174,32 +242,6 @@ func viewStarString(str *string) string {
 	return *str
 }
 
-func (svc QuickSightService) UpdateUserCustomPermission(ctx context.Context, user *User, customPermissionName *string) error {
-	log.Printf("[debug] call UpdateUserCustomPermission(ctx, %s, %s)", user, viewStarString(user.CustomPermissionsName))
-	if !user.IsNeedUpdateCustomPermission(customPermissionName) {
-		log.Printf("[debug] user %s nothing todo", *user.UserName)
-		return nil
-	}
-	input := &quicksight.UpdateUserInput{
-		AwsAccountId: aws.String(svc.awsAccountID),
-		Namespace:    aws.String(user.Namespace),
-		Email:        user.Email,
-		UserName:     user.UserName,
-		Role:         user.Role,
-	}
-	if customPermissionName == nil {
-		input.UnapplyCustomPermissions = true
-	} else {
-		input.CustomPermissionsName = customPermissionName
-	}
-	output, err := svc.client.UpdateUser(ctx, input)
-	if err != nil {
-		return err
-	}
-	log.Printf("[info] update user %s custom permission: %s => %s", viewStarString(user.UserName), viewStarString(user.CustomPermissionsName), viewStarString(output.User.CustomPermissionsName))
-	return nil
-}
-
 func (svc QuickSightService) GetGroups(ctx context.Context, namespace string) (Groups, error) {
 	g := newGroups()
 
@@ -235,9 +277,16 @@ func (svc QuickSightService) GetGroups(ctx context.Context, namespace string) (G
 	return g, nil
 }
 
+// ApplyGroupsOptions are the options of planning the groups of a namespace by Config.PlanNamespace.
 type ApplyGroupsOptions struct {
 	noDeleteGroup           bool
 	noDeleteGroupMembership bool
+	ignore                  *ignoreList
+	// ignoredUsers are the user names of the users ignored by the email.
+	ignoredUsers map[string]bool
+	// expiredGroups are the groups of the users granted by the expired rules and exceptions,
+	// whose memberships are removed even with create_only.
+	expiredGroups map[string]map[string]bool
 }
 
 func WithCreateOnly(f bool) func(*ApplyGroupsOptions) error {
@@ -248,69 +297,119 @@ func WithCreateOnly(f bool) func(*ApplyGroupsOptions) error {
 	}
 }
 
-func (svc QuickSightService) ApplyGroups(ctx context.Context, namespace string, groups Groups, optFns ...func(opt *ApplyGroupsOptions) error) error {
-	var opts ApplyGroupsOptions
-	for _, optFn := range optFns {
-		if err := optFn(&opts); err != nil {
-			return err
+// ApplyChanges applies the changes in order.
+// A failed custom permission update affects only the user, so the following changes are still applied,
+// and the errors are returned together. It stops at the first other error, since the following changes
+// may depend on the failed one, such as the memberships of a group failed to create.
+// A ChangeEvent is emitted for each change, including the failed one.
+// It also stops if the audit record of a change is not written.
+func (svc QuickSightService) ApplyChanges(ctx context.Context, changes []*Change) error {
+	errs := make([]error, 0)
+	for _, c := range changes {
+		start := time.Now()
+		requestID, err := svc.applyChange(ctx, c)
+		e := &ChangeEvent{
+			Time:      start,
+			AccountID: svc.awsAccountID,
+			Change:    c,
+			RequestID: requestID,
+			Duration:  time.Since(start),
+			DryRun:    svc.dryRun,
+			Error:     err,
+		}
+		svc.emit(e)
+		if err != nil && c.Type == ChangeUpdateCustomPermission {
+			errs = append(errs, fmt.Errorf("update custom permission of %s: %w", c.UserName, err))
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+		if svc.auditor != nil && !svc.dryRun {
+			if aerr := svc.auditor.record(ctx, e, svc.principal); aerr != nil {
+				log.Printf("[error] failed to write the audit record: %s", aerr)
+				return errors.Join(append(errs, fmt.Errorf("audit: %w", aerr))...)
+			}
+		}
+		if err != nil && c.Type != ChangeUpdateCustomPermission {
+			return errors.Join(errs...)
 		}
 	}
+	return errors.Join(errs...)
+}
 
-	nowGroups, err := svc.GetGroups(ctx, namespace)
-	if err != nil {
-		return err
+func (svc QuickSightService) emit(e *ChangeEvent) {
+	logChange := svc.logChange
+	if logChange == nil {
+		logChange = LogChangeEvent
+	}
+	logChange(e)
+	for _, h := range svc.changeEventHandlers {
+		h(e)
 	}
-	createGroups, _, deleteGroups := nowGroups.DiffGroup(groups)
-	for _, g := range createGroups {
-		_, err := svc.client.CreateGroup(ctx, &quicksight.CreateGroupInput{
+}
+
+// applyChange calls the QuickSight API for the change, and returns the request ID.
+func (svc QuickSightService) applyChange(ctx context.Context, c *Change) (string, error) {
+	switch c.Type {
+	case ChangeUpdateCustomPermission:
+		input := &quicksight.UpdateUserInput{
 			AwsAccountId: aws.String(svc.awsAccountID),
-			Namespace:    aws.String(namespace),
-			GroupName:    aws.String(g),
+			Namespace:    aws.String(c.Namespace),
+			Email:        aws.String(c.Email),
+			UserName:     aws.String(c.UserName),
+			Role:         types.UserRole(c.Role),
+		}
+		if c.After == nil {
+			input.UnapplyCustomPermissions = true
+		} else {
+			input.CustomPermissionsName = c.After
+		}
+		output, err := svc.client.UpdateUser(ctx, input)
+		if err != nil {
+			return "", err
+		}
+		return aws.ToString(output.RequestId), nil
+	case ChangeCreateGroup:
+		output, err := svc.client.CreateGroup(ctx, &quicksight.CreateGroupInput{
+			AwsAccountId: aws.String(svc.awsAccountID),
+			Namespace:    aws.String(c.Namespace),
+			GroupName:    aws.String(c.GroupName),
 		})
 		if err != nil {
-			return err
+			return "", err
 		}
-		log.Printf("[info] create group %s", g)
-	}
-	createMembership, _, deleteMembership := nowGroups.DiffMembership(groups)
-	for _, gm := range createMembership {
-		_, err := svc.client.CreateGroupMembership(ctx, &quicksight.CreateGroupMembershipInput{
+		return aws.ToString(output.RequestId), nil
+	case ChangeAddMembership:
+		output, err := svc.client.CreateGroupMembership(ctx, &quicksight.CreateGroupMembershipInput{
 			AwsAccountId: aws.String(svc.awsAccountID),
-			Namespace:    aws.String(namespace),
-			GroupName:    aws.String(gm.GroupName),
-			MemberName:   aws.String(gm.UserName),
+			Namespace:    aws.String(c.Namespace),
+			GroupName:    aws.String(c.GroupName),
+			MemberName:   aws.String(c.UserName),
 		})
 		if err != nil {
-			return err
+			return "", err
 		}
-		log.Printf("[info] create group membership %s in %s", gm.UserName, gm.GroupName)
-	}
-	if !opts.noDeleteGroupMembership {
-		for _, gm := range deleteMembership {
-			_, err := svc.client.DeleteGroupMembership(ctx, &quicksight.DeleteGroupMembershipInput{
-				AwsAccountId: aws.String(svc.awsAccountID),
-				Namespace:    aws.String(namespace),
-				GroupName:    aws.String(gm.GroupName),
-				MemberName:   aws.String(gm.UserName),
-			})
-			if err != nil {
-				return err
-			}
-			log.Printf("[info] delete group membership %s in %s", gm.UserName, gm.GroupName)
+		return aws.ToString(output.RequestId), nil
+	case ChangeRemoveMembership:
+		output, err := svc.client.DeleteGroupMembership(ctx, &quicksight.DeleteGroupMembershipInput{
+			AwsAccountId: aws.String(svc.awsAccountID),
+			Namespace:    aws.String(c.Namespace),
+			GroupName:    aws.String(c.GroupName),
+			MemberName:   aws.String(c.UserName),
+		})
+		if err != nil {
+			return "", err
 		}
-	}
-	if !opts.noDeleteGroup {
-		for _, g := range deleteGroups {
-			_, err := svc.client.DeleteGroup(ctx, &quicksight.DeleteGroupInput{
-				AwsAccountId: aws.String(svc.awsAccountID),
-				Namespace:    aws.String(namespace),
-				GroupName:    aws.String(g),
-			})
-			if err != nil {
-				return err
-			}
-			log.Printf("[info] delete group %s", g)
+		return aws.ToString(output.RequestId), nil
+	case ChangeDeleteGroup:
+		output, err := svc.client.DeleteGroup(ctx, &quicksight.DeleteGroupInput{
+			AwsAccountId: aws.String(svc.awsAccountID),
+			Namespace:    aws.String(c.Namespace),
+			GroupName:    aws.String(c.GroupName),
+		})
+		if err != nil {
+			return "", err
 		}
+		return aws.ToString(output.RequestId), nil
 	}
-	return nil
+	return "", fmt.Errorf("unknown change type %s", c.Type)
 }
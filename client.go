@@ -0,0 +1,91 @@
+package qsgpm
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/quicksight"
+)
+
+// observedClient calls observe after each API call with the operation name, the latency and the error.
+type observedClient struct {
+	QuickSightClient
+	observe func(operation string, latency time.Duration, err error)
+}
+
+func (c observedClient) ListUsers(ctx context.Context, params *quicksight.ListUsersInput, optFns ...func(*quicksight.Options)) (*quicksight.ListUsersOutput, error) {
+	start := time.Now()
+	output, err := c.QuickSightClient.ListUsers(ctx, params, optFns...)
+	c.observe("ListUsers", time.Since(start), err)
+	return output, err
+}
+
+func (c observedClient) DescribeUser(ctx context.Context, params *quicksight.DescribeUserInput, optFns ...func(*quicksight.Options)) (*quicksight.DescribeUserOutput, error) {
+	start := time.Now()
+	output, err := c.QuickSightClient.DescribeUser(ctx, params, optFns...)
+	c.observe("DescribeUser", time.Since(start), err)
+	return output, err
+}
+
+func (c observedClient) ListUserGroups(ctx context.Context, params *quicksight.ListUserGroupsInput, optFns ...func(*quicksight.Options)) (*quicksight.ListUserGroupsOutput, error) {
+	start := time.Now()
+	output, err := c.QuickSightClient.ListUserGroups(ctx, params, optFns...)
+	c.observe("ListUserGroups", time.Since(start), err)
+	return output, err
+}
+
+func (c observedClient) UpdateUser(ctx context.Context, params *quicksight.UpdateUserInput, optFns ...func(*quicksight.Options)) (*quicksight.UpdateUserOutput, error) {
+	start := time.Now()
+	output, err := c.QuickSightClient.UpdateUser(ctx, params, optFns...)
+	c.observe("UpdateUser", time.Since(start), err)
+	return output, err
+}
+
+func (c observedClient) ListGroups(ctx context.Context, params *quicksight.ListGroupsInput, optFns ...func(*quicksight.Options)) (*quicksight.ListGroupsOutput, error) {
+	start := time.Now()
+	output, err := c.QuickSightClient.ListGroups(ctx, params, optFns...)
+	c.observe("ListGroups", time.Since(start), err)
+	return output, err
+}
+
+func (c observedClient) DescribeGroup(ctx context.Context, params *quicksight.DescribeGroupInput, optFns ...func(*quicksight.Options)) (*quicksight.DescribeGroupOutput, error) {
+	start := time.Now()
+	output, err := c.QuickSightClient.DescribeGroup(ctx, params, optFns...)
+	c.observe("DescribeGroup", time.Since(start), err)
+	return output, err
+}
+
+func (c observedClient) CreateGroup(ctx context.Context, params *quicksight.CreateGroupInput, optFns ...func(*quicksight.Options)) (*quicksight.CreateGroupOutput, error) {
+	start := time.Now()
+	output, err := c.QuickSightClient.CreateGroup(ctx, params, optFns...)
+	c.observe("CreateGroup", time.Since(start), err)
+	return output, err
+}
+
+func (c observedClient) DeleteGroup(ctx context.Context, params *quicksight.DeleteGroupInput, optFns ...func(*quicksight.Options)) (*quicksight.DeleteGroupOutput, error) {
+	start := time.Now()
+	output, err := c.QuickSightClient.DeleteGroup(ctx, params, optFns...)
+	c.observe("DeleteGroup", time.Since(start), err)
+	return output, err
+}
+
+func (c observedClient) ListGroupMemberships(ctx context.Context, params *quicksight.ListGroupMembershipsInput, optFns ...func(*quicksight.Options)) (*quicksight.ListGroupMembershipsOutput, error) {
+	start := time.Now()
+	output, err := c.QuickSightClient.ListGroupMemberships(ctx, params, optFns...)
+	c.observe("ListGroupMemberships", time.Since(start), err)
+	return output, err
+}
+
+func (c observedClient) CreateGroupMembership(ctx context.Context, params *quicksight.CreateGroupMembershipInput, optFns ...func(*quicksight.Options)) (*quicksight.CreateGroupMembershipOutput, error) {
+	start := time.Now()
+	output, err := c.QuickSightClient.CreateGroupMembership(ctx, params, optFns...)
+	c.observe("CreateGroupMembership", time.Since(start), err)
+	return output, err
+}
+
+func (c observedClient) DeleteGroupMembership(ctx context.Context, params *quicksight.DeleteGroupMembershipInput, optFns ...func(*quicksight.Options)) (*quicksight.DeleteGroupMembershipOutput, error) {
+	start := time.Now()
+	output, err := c.QuickSightClient.DeleteGroupMembership(ctx, params, optFns...)
+	c.observe("DeleteGroupMembership", time.Since(start), err)
+	return output, err
+}
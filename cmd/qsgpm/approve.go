@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/urfave/cli/v2"
+)
+
+// approve applies the pending plan, or lists the pending plans if no plan ID is given.
+func approve(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	if cfg.Approval == nil {
+		return errors.New("no approval section in the config")
+	}
+	if c.NArg() == 0 {
+		plans, err := qsgpm.ListPendingPlans(cfg.Approval.Dir)
+		if err != nil {
+			return err
+		}
+		if len(plans) == 0 {
+			fmt.Println("No pending plans.")
+			return nil
+		}
+		for _, p := range plans {
+			fmt.Println(p)
+		}
+		return nil
+	}
+	if c.NArg() > 1 {
+		return errors.New("too many arguments, approve one plan at a time")
+	}
+	if c.Bool("show") {
+		p, err := qsgpm.LoadPendingPlan(cfg.Approval.Dir, c.Args().First())
+		if err != nil {
+			return err
+		}
+		fmt.Println(p)
+		for _, change := range p.Plan.Changes {
+			fmt.Println(change)
+		}
+		return nil
+	}
+	// the approver is given explicitly, since the user who runs qsgpm may be the requester.
+	approver := c.String("approver")
+	if approver == "" {
+		return errors.New("--approver is required")
+	}
+	app, err := newApp(c, cfg)
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+	summary, err := app.Approve(c.Context, c.Args().First(), approver, qsgpm.RunOption{
+		DryRun: c.Bool("dry-run"),
+	})
+	return writeRunOutputs(c, summary, err)
+}
+
+// currentUser is the user who runs qsgpm, recorded as the requester of the pending plans.
+func currentUser() string {
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+	return os.Getenv("USERNAME")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/urfave/cli/v2"
+)
+
+// errDriftDetected makes the exit code 2.
+var errDriftDetected = errors.New("drift detected")
+
+func drift(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	report, err := newDriftReport(c, cfg)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if output := c.String("output"); output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	switch c.String("format") {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	case "text":
+		for _, d := range report.Drifts {
+			if _, err := fmt.Fprintln(w, d); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown format %s", c.String("format"))
+	}
+	if err := qsgpm.NewNotifier(cfg.Notifications).Notify(c.Context, qsgpm.NewDriftNotification(report)); err != nil {
+		log.Printf("[warn] %s", err)
+	}
+	if report.HasDrift() {
+		return fmt.Errorf("%w: %d difference(s) found", errDriftDetected, len(report.Drifts))
+	}
+	return nil
+}
+
+// newDriftReport reports the drifts of the snapshot of the state flag, or of AWS.
+func newDriftReport(c *cli.Context, cfg *qsgpm.Config) (*qsgpm.DriftReport, error) {
+	if path := c.String("state"); path != "" {
+		snapshot, err := qsgpm.LoadSnapshot(path)
+		if err != nil {
+			return nil, err
+		}
+		return cfg.DriftSnapshot(snapshot)
+	}
+	app, err := newApp(c, cfg, qsgpm.WithReadOnly())
+	if err != nil {
+		return nil, err
+	}
+	defer app.Close()
+	return app.Drift(c.Context)
+}
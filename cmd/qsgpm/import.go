@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/urfave/cli/v2"
+)
+
+func importConfig(c *cli.Context) error {
+	if !c.Bool("generate-config") {
+		return errors.New("-generate-config is required")
+	}
+	var snapshot *qsgpm.Snapshot
+	if path := c.String("state"); path != "" {
+		var err error
+		snapshot, err = qsgpm.LoadSnapshot(path)
+		if err != nil {
+			return err
+		}
+	} else {
+		cfg, err := loadConfig(c)
+		if err != nil {
+			return err
+		}
+		app, err := newApp(c, cfg, qsgpm.WithReadOnly())
+		if err != nil {
+			return err
+		}
+		defer app.Close()
+		snapshot, err = app.Export(c.Context)
+		if err != nil {
+			return err
+		}
+	}
+	bs, issues, err := qsgpm.GenerateConfig(snapshot)
+	if err != nil {
+		return err
+	}
+	for _, issue := range issues {
+		log.Printf("[warn] %s", issue)
+	}
+	if output := c.String("output"); output != "" {
+		return os.WriteFile(output, bs, 0644)
+	}
+	_, err = os.Stdout.Write(bs)
+	return err
+}
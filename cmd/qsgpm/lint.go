@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+)
+
+func lint(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	issues := cfg.Lint()
+	if len(issues) == 0 {
+		fmt.Fprintln(os.Stdout, "no issues found")
+		return nil
+	}
+	for _, issue := range issues {
+		fmt.Fprintln(os.Stdout, issue)
+	}
+	return fmt.Errorf("%d issue(s) found in rules", len(issues))
+}
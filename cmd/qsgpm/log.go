@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fujiwara/logutils"
+	"github.com/mashiike/qsgpm"
+)
+
+// jsonLogWriter writes the log lines with the level prefix as JSON records.
+type jsonLogWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *jsonLogWriter) Write(p []byte) (int, error) {
+	line := bytes.TrimRight(p, "\n")
+	level := "info"
+	if bytes.HasPrefix(line, []byte("[")) {
+		if i := bytes.IndexByte(line, ']'); i > 0 {
+			level = string(line[1:i])
+			line = bytes.TrimLeft(line[i+1:], " ")
+		}
+	}
+	if err := w.writeRecord(map[string]interface{}{
+		"level":   level,
+		"message": string(line),
+	}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *jsonLogWriter) writeRecord(record map[string]interface{}) error {
+	record["time"] = time.Now().Format(time.RFC3339Nano)
+	bs, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.w.Write(append(bs, '\n'))
+	return err
+}
+
+// logChangeEvent logs the change event as a JSON record with the fields of the change.
+func (w *jsonLogWriter) logChangeEvent(e *qsgpm.ChangeEvent) {
+	level := "info"
+	if e.Error != nil {
+		level = "error"
+	}
+	if !filter.Check([]byte("[" + level + "]")) {
+		return
+	}
+	record := map[string]interface{}{
+		"level":       level,
+		"message":     e.Message(),
+		"account_id":  e.AccountID,
+		"namespace":   e.Namespace,
+		"action":      e.Type,
+		"duration_ms": float64(e.Duration) / float64(time.Millisecond),
+	}
+	for key, value := range map[string]string{
+		"target":     e.Target,
+		"user":       e.UserName,
+		"group":      e.GroupName,
+		"rule":       e.Rule,
+		"reason":     e.Reason,
+		"request_id": e.RequestID,
+	} {
+		if value != "" {
+			record[key] = value
+		}
+	}
+	if e.DryRun {
+		record["dry_run"] = true
+	}
+	if e.Error != nil {
+		record["error"] = e.Error.Error()
+	}
+	if err := w.writeRecord(record); err != nil {
+		log.Printf("[warn] failed to write log: %s", err)
+	}
+}
+
+var jsonLog *jsonLogWriter
+
+// setupLog sets the log level and format, text or json.
+func setupLog(level string, format string) {
+	filter.MinLevel = logutils.LogLevel(level)
+	if format == "json" {
+		jsonLog = &jsonLogWriter{w: os.Stderr}
+		filter.ModifierFuncs = nil
+		filter.Writer = jsonLog
+		log.SetFlags(0)
+	}
+	log.SetOutput(filter)
+}
+
+// changeEventWriter writes the change events as JSON lines.
+type changeEventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	c   io.Closer
+}
+
+func openChangeEventWriter(path string) (*changeEventWriter, error) {
+	if path == "-" {
+		return &changeEventWriter{enc: json.NewEncoder(os.Stdout)}, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &changeEventWriter{enc: json.NewEncoder(f), c: f}, nil
+}
+
+func (w *changeEventWriter) handle(e *qsgpm.ChangeEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.enc.Encode(e); err != nil {
+		log.Printf("[warn] failed to write change event: %s", err)
+	}
+}
+
+func (w *changeEventWriter) Close() error {
+	if w.c == nil {
+		return nil
+	}
+	return w.c.Close()
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sort"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/fatih/color"
@@ -17,6 +22,9 @@ import (
 )
 
 var Version string = "current"
+var changeEvents *changeEventWriter
+var metrics *qsgpm.Metrics
+
 var filter = &logutils.LevelFilter{
 	Levels: []logutils.LogLevel{"debug", "info", "notice", "warn", "error"},
 	ModifierFuncs: []logutils.ModifierFunc{
@@ -35,12 +43,7 @@ func main() {
 		Usage:     "A commandline tool for management of QuickSight Group and CustomPermission",
 		UsageText: "qsgpm -config <config file>",
 		Flags: []cli.Flag{
-			&cli.StringFlag{
-				Name:    "config",
-				Aliases: []string{"c"},
-				Usage:   "config file path",
-				EnvVars: []string{"CONFIG", "QSGPM_CONFIG"},
-			},
+			newConfigFlag(),
 			&cli.StringFlag{
 				Name:    "log-level",
 				Aliases: []string{"l"},
@@ -48,57 +51,541 @@ func main() {
 				Value:   "info",
 				EnvVars: []string{"QSGPM_LOG_LEVEL"},
 			},
+			&cli.StringFlag{
+				Name:    "log-format",
+				Usage:   "output log format (text|json)",
+				Value:   "text",
+				EnvVars: []string{"QSGPM_LOG_FORMAT"},
+			},
+			&cli.StringFlag{
+				Name:    "change-events",
+				Usage:   "file path to append the applied changes as JSON lines, - for stdout",
+				EnvVars: []string{"QSGPM_CHANGE_EVENTS"},
+			},
+			&cli.StringFlag{
+				Name:    "summary",
+				Usage:   "file path to write the run summary, in Markdown if the extension is .md, otherwise in JSON",
+				EnvVars: []string{"QSGPM_SUMMARY"},
+			},
+			&cli.StringFlag{
+				Name:    "metrics-textfile",
+				Usage:   "file path to write the metrics in the Prometheus text format, for the textfile collector of node_exporter",
+				EnvVars: []string{"QSGPM_METRICS_TEXTFILE"},
+			},
 			&cli.BoolFlag{
 				Name:    "dry-run",
 				EnvVars: []string{"QSGPM_DRY_RUN"},
 			},
+			&cli.StringFlag{
+				Name:    "aws-profile",
+				Usage:   "AWS shared config profile",
+				EnvVars: []string{"QSGPM_AWS_PROFILE"},
+			},
+			&cli.StringFlag{
+				Name:    "aws-region",
+				Usage:   "AWS region",
+				EnvVars: []string{"QSGPM_AWS_REGION"},
+			},
+			&cli.StringFlag{
+				Name:    "assume-role-arn",
+				Usage:   "IAM role ARN to assume",
+				EnvVars: []string{"QSGPM_ASSUME_ROLE_ARN"},
+			},
+			&cli.StringFlag{
+				Name:    "external-id",
+				Usage:   "external ID for assuming the role",
+				EnvVars: []string{"QSGPM_EXTERNAL_ID"},
+			},
+			&cli.StringFlag{
+				Name:    "endpoint",
+				Usage:   "QuickSight API endpoint URL",
+				EnvVars: []string{"QSGPM_ENDPOINT"},
+			},
+			&cli.StringFlag{
+				Name:    "account-id",
+				Usage:   "AWS account ID (skip STS GetCallerIdentity)",
+				EnvVars: []string{"QSGPM_ACCOUNT_ID"},
+			},
 		},
 		Action: run,
+		Commands: []*cli.Command{
+			{
+				Name:      "apply",
+				Usage:     "apply the config, only to the specified users if any",
+				UsageText: "qsgpm apply -config <config file> [-user <user name>]... [-namespace <namespace>]...",
+				Flags: []cli.Flag{
+					newConfigFlag(),
+					&cli.StringSliceFlag{
+						Name:  "user",
+						Usage: "user name to reconcile, such as alice or Developer/alice@example.com; only the user and the groups relevant to the user are read",
+					},
+					&cli.StringSliceFlag{
+						Name:  "namespace",
+						Usage: "namespace to reconcile (default: all namespaces of the config)",
+					},
+				},
+				Action: apply,
+			},
+			{
+				Name:        "approve",
+				Usage:       "apply a plan pending for approval, or list the pending plans",
+				UsageText:   "qsgpm approve -config <config file> [-approver <name>] [-show] [<plan id>]",
+				Description: "the plan is applied only if the current plan is the same, otherwise it is rejected as stale",
+				Flags: []cli.Flag{
+					newConfigFlag(),
+					&cli.StringFlag{
+						Name:    "approver",
+						Usage:   "who approves the plan, recorded in the approved plan, must differ from the requester (required)",
+						EnvVars: []string{"QSGPM_APPROVER"},
+					},
+					&cli.BoolFlag{
+						Name:  "show",
+						Usage: "show the changes of the plan without applying it",
+					},
+				},
+				Action: approve,
+			},
+			{
+				Name:      "serve",
+				Usage:     "run the reconciliation periodically, serving the status and on-demand runs over HTTP",
+				UsageText: "qsgpm serve -config <config file> [-interval <duration>] [-listen <address>]",
+				Flags: []cli.Flag{
+					newConfigFlag(),
+					&cli.DurationFlag{
+						Name:    "interval",
+						Usage:   "interval of the runs",
+						Value:   15 * time.Minute,
+						EnvVars: []string{"QSGPM_INTERVAL"},
+					},
+					&cli.DurationFlag{
+						Name:        "jitter",
+						Usage:       "maximum random delay added to each interval",
+						DefaultText: "10% of the interval",
+						EnvVars:     []string{"QSGPM_JITTER"},
+					},
+					&cli.DurationFlag{
+						Name:    "reload-interval",
+						Usage:   "interval of checking the config changes",
+						Value:   30 * time.Second,
+						EnvVars: []string{"QSGPM_RELOAD_INTERVAL"},
+					},
+					&cli.StringFlag{
+						Name:    "listen",
+						Usage:   "address to serve /healthz, /metrics, /last-run and /run",
+						Value:   ":8080",
+						EnvVars: []string{"QSGPM_LISTEN"},
+					},
+				},
+				Action: serve,
+			},
+			{
+				Name:      "validate",
+				Usage:     "validate config files without accessing AWS",
+				UsageText: "qsgpm validate -config <config file>",
+				Flags: []cli.Flag{
+					newConfigFlag(),
+				},
+				Action: validate,
+			},
+			{
+				Name:      "lint",
+				Usage:     "find unreachable, shadowed and redundant rules",
+				UsageText: "qsgpm lint -config <config file>",
+				Flags: []cli.Flag{
+					newConfigFlag(),
+				},
+				Action: lint,
+			},
+			{
+				Name:      "simulate",
+				Usage:     "evaluate the rules for users in a file without accessing AWS",
+				UsageText: "qsgpm simulate -config <config file> [-users <users file> | -state <snapshot file>]",
+				Flags: []cli.Flag{
+					newConfigFlag(),
+					&cli.StringFlag{
+						Name:  "users",
+						Usage: "users file path (JSON or CSV)",
+					},
+					&cli.StringFlag{
+						Name:  "state",
+						Usage: "snapshot file path exported by qsgpm export, used as the users",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "output format (text|json)",
+						Value: "text",
+					},
+				},
+				Action: simulate,
+			},
+			{
+				Name:      "plan",
+				Usage:     "show the changes to be applied, without applying them",
+				UsageText: "qsgpm plan -config <config file> [-state <snapshot file>]",
+				Flags: []cli.Flag{
+					newConfigFlag(),
+					&cli.StringFlag{
+						Name:  "state",
+						Usage: "snapshot file path exported by qsgpm export, used as the current state instead of AWS",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "output format (text|json)",
+						Value: "text",
+					},
+				},
+				Action: plan,
+			},
+			{
+				Name:        "drift",
+				Usage:       "detect the differences between the current state and the config, without applying them",
+				UsageText:   "qsgpm drift -config <config file> [-state <snapshot file>] [-output <report file>]",
+				Description: "exits with 0 when in sync, 2 when drift exists, and 1 on errors",
+				Flags: []cli.Flag{
+					newConfigFlag(),
+					&cli.StringFlag{
+						Name:  "state",
+						Usage: "snapshot file path exported by qsgpm export, used as the current state instead of AWS",
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "report file path (default: stdout)",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "report format (text|json)",
+						Value: "text",
+					},
+				},
+				Action: drift,
+			},
+			{
+				Name:      "export",
+				Usage:     "export the current users, groups, memberships and custom permissions to a snapshot file",
+				UsageText: "qsgpm export -config <config file> [-output <snapshot file>]",
+				Flags: []cli.Flag{
+					newConfigFlag(),
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "snapshot file path (default: stdout)",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "output format (json|yaml), guessed by the output file extension if omitted",
+					},
+				},
+				Action: export,
+			},
+			{
+				Name:      "import",
+				Usage:     "generate a starter config which reproduces the current groups, memberships and custom permissions",
+				UsageText: "qsgpm import -generate-config -config <config file> [-state <snapshot file>] [-output <config file>]",
+				Flags: []cli.Flag{
+					newConfigFlag(),
+					&cli.BoolFlag{
+						Name:  "generate-config",
+						Usage: "generate rules from the current state",
+					},
+					&cli.StringFlag{
+						Name:  "state",
+						Usage: "snapshot file path exported by qsgpm export, used as the current state instead of AWS",
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "generated config file path (default: stdout)",
+					},
+				},
+				Action: importConfig,
+			},
+			{
+				Name:      "schema",
+				Usage:     "print the JSON Schema of the config file",
+				UsageText: "qsgpm schema",
+				Action:    schema,
+			},
+		},
 	}
 	sort.Sort(cli.FlagsByName(cliApp.Flags))
 	cliApp.Version = Version
 	cliApp.EnableBashCompletion = true
 	cliApp.Before = func(c *cli.Context) error {
-		filter.MinLevel = logutils.LogLevel(c.String("log-level"))
-		log.SetOutput(filter)
+		switch c.String("log-format") {
+		case "text", "json":
+		default:
+			return fmt.Errorf("unknown log format %s", c.String("log-format"))
+		}
+		setupLog(c.String("log-level"), c.String("log-format"))
 		log.Println("[debug] set log level:", c.String("log-level"))
+		if path := c.String("change-events"); path != "" {
+			w, err := openChangeEventWriter(path)
+			if err != nil {
+				return err
+			}
+			changeEvents = w
+		}
+		return nil
+	}
+	cliApp.After = func(c *cli.Context) error {
+		if changeEvents != nil {
+			return changeEvents.Close()
+		}
 		return nil
 	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
 	defer cancel()
 	if err := cliApp.RunContext(ctx, os.Args); err != nil {
+		if errors.Is(err, errDriftDetected) {
+			log.Printf("[warn] %s", err)
+			cancel()
+			os.Exit(2)
+		}
+		var approvalErr *qsgpm.ApprovalRequiredError
+		if errors.As(err, &approvalErr) {
+			log.Printf("[warn] %s", err)
+			cancel()
+			os.Exit(3)
+		}
 		log.Printf("[error] %s", err)
+		cancel()
+		os.Exit(1)
 	}
 }
 
+func newConfigFlag() cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:    "config",
+		Aliases: []string{"c"},
+		Usage:   "config file path, http(s) URL or - for stdin, merged in order if specified multiple times",
+		EnvVars: []string{"CONFIG", "QSGPM_CONFIG"},
+	}
+}
+
+// configPaths returns the config flag of the command, or of the global options.
+func configPaths(c *cli.Context) []string {
+	for _, lc := range c.Lineage() {
+		if paths := lc.StringSlice("config"); len(paths) > 0 {
+			return paths
+		}
+	}
+	return nil
+}
+
+func loadConfig(c *cli.Context) (*qsgpm.Config, error) {
+	load, err := newConfigLoader(c)
+	if err != nil {
+		return nil, err
+	}
+	return load(c.Context)
+}
+
+// newConfigLoader returns the loader of the config flag, which reuses the sources on reloads.
+func newConfigLoader(c *cli.Context) (qsgpm.ConfigLoader, error) {
+	sources, err := qsgpm.NewConfigSources(configPaths(c)...)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) (*qsgpm.Config, error) {
+		cfg := qsgpm.NewDefaultConfig()
+		if err := cfg.LoadSources(ctx, sources...); err != nil {
+			return nil, err
+		}
+		if err := cfg.ValidateVersion(Version); err != nil {
+			return nil, err
+		}
+		// the pending plans on Lambda are neither writable nor shared with qsgpm approve.
+		if cfg.Approval != nil && isLambda() {
+			return nil, errors.New("the approval section is not supported on AWS Lambda, since the pending plans can not be shared with qsgpm approve")
+		}
+		return cfg, nil
+	}, nil
+}
+
 func isLambda() bool {
 	return strings.HasPrefix(os.Getenv("AWS_EXECUTION_ENV"), "AWS_Lambda") ||
 		os.Getenv("AWS_LAMBDA_RUNTIME_API") != ""
 }
 
+func newApp(c *cli.Context, cfg *qsgpm.Config, extraOptFns ...func(*qsgpm.AppOptions) error) (*qsgpm.App, error) {
+	optFns := []func(*qsgpm.AppOptions) error{
+		qsgpm.WithAWSConfig(&qsgpm.AWSConfig{
+			Profile:       c.String("aws-profile"),
+			Region:        c.String("aws-region"),
+			AssumeRoleARN: c.String("assume-role-arn"),
+			ExternalID:    c.String("external-id"),
+			Endpoint:      c.String("endpoint"),
+			AccountID:     c.String("account-id"),
+		}),
+	}
+	if jsonLog != nil {
+		optFns = append(optFns, qsgpm.WithChangeLogger(jsonLog.logChangeEvent))
+	}
+	if changeEvents != nil {
+		optFns = append(optFns, qsgpm.WithChangeEventHandler(changeEvents.handle))
+	}
+	if metrics != nil {
+		optFns = append(optFns, qsgpm.WithMetrics(metrics))
+	}
+	return qsgpm.New(c.Context, cfg, append(optFns, extraOptFns...)...)
+}
+
+// newServer returns the server which reloads the config flag.
+func newServer(c *cli.Context, optFns ...func(*qsgpm.ServerOptions) error) (*qsgpm.Server, error) {
+	load, err := newConfigLoader(c)
+	if err != nil {
+		return nil, err
+	}
+	return qsgpm.NewServer(
+		c.Context,
+		load,
+		func(_ context.Context, cfg *qsgpm.Config, optFns ...func(*qsgpm.AppOptions) error) (*qsgpm.App, error) {
+			return newApp(c, cfg, optFns...)
+		},
+		optFns...,
+	)
+}
+
 func run(c *cli.Context) error {
+	if isLambda() {
+		return runLambda(c)
+	}
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	if c.String("metrics-textfile") != "" {
+		metrics = qsgpm.NewMetrics()
+	}
+	app, err := newApp(c, cfg)
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+	summary, err := app.RunWithSummary(c.Context, qsgpm.RunOption{
+		DryRun:      c.Bool("dry-run"),
+		RequestedBy: currentUser(),
+	})
+	return writeRunOutputs(c, summary, err)
+}
 
-	cfg := qsgpm.NewDefaultConfig()
-	if err := cfg.Load(c.String("config")); err != nil {
+// runLambda starts the Lambda handler, which reloads the config on each invocation.
+func runLambda(c *cli.Context) error {
+	s, err := newServer(c)
+	if err != nil {
 		return err
 	}
-	if err := cfg.ValidateVersion(Version); err != nil {
+	defer s.Close()
+	lambda.Start(func(ctx context.Context, payload json.RawMessage) (*qsgpm.RunSummary, error) {
+		opt, ok, err := qsgpm.ParseLambdaEvent(payload)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			log.Printf("[info] ignore the event: %s", payload)
+			return nil, nil
+		}
+		opt.DryRun = opt.DryRun || c.Bool("dry-run")
+		if len(opt.Users) > 0 {
+			log.Printf("[info] reconcile users %s", strings.Join(opt.Users, ", "))
+		}
+		if _, err := s.Reload(ctx); err != nil {
+			log.Printf("[error] failed to reload the config, keep the current config: %s", err)
+		}
+		result := s.RunOnce(ctx, opt)
+		// the metrics are written to stdout as the EMF logs, which CloudWatch Logs extracts.
+		if err := s.Metrics().WriteEMF(os.Stdout, "qsgpm"); err != nil {
+			log.Printf("[warn] failed to write metrics: %s", err)
+		}
+		s.Metrics().Reset()
+		if result.Error != "" {
+			return result.Summary, errors.New(result.Error)
+		}
+		return result.Summary, nil
+	})
+	return nil
+}
+
+// apply reconciles only the users if specified, otherwise runs as the default action.
+func apply(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
 		return err
 	}
-	app, err := qsgpm.New(c.Context, cfg)
+	if c.String("metrics-textfile") != "" {
+		metrics = qsgpm.NewMetrics()
+	}
+	app, err := newApp(c, cfg)
 	if err != nil {
 		return err
 	}
-	if isLambda() {
-		lambda.Start(func(ctx context.Context) error {
-			return app.Run(ctx, qsgpm.RunOption{
-				DryRun: false,
-			})
-		})
-		return nil
+	defer app.Close()
+	opt := qsgpm.RunOption{
+		DryRun:      c.Bool("dry-run"),
+		Namespaces:  c.StringSlice("namespace"),
+		RequestedBy: currentUser(),
 	}
-	return app.Run(c.Context, qsgpm.RunOption{
-		DryRun: c.Bool("dry-run"),
-	})
+	var summary *qsgpm.RunSummary
+	if users := c.StringSlice("user"); len(users) > 0 {
+		summary, err = app.ReconcileUsers(c.Context, users, opt)
+	} else {
+		summary, err = app.RunWithSummary(c.Context, opt)
+	}
+	return writeRunOutputs(c, summary, err)
+}
+
+// writeRunOutputs writes the summary and the metrics of the run, and returns the error of the run joined with the write errors.
+func writeRunOutputs(c *cli.Context, summary *qsgpm.RunSummary, err error) error {
+	if path := c.String("summary"); path != "" {
+		if werr := writeSummary(path, summary); werr != nil {
+			err = errors.Join(err, werr)
+		}
+	}
+	if path := c.String("metrics-textfile"); path != "" {
+		if werr := writeMetricsTextfile(path, metrics); werr != nil {
+			err = errors.Join(err, werr)
+		}
+	}
+	return err
+}
+
+// writeMetricsTextfile writes the metrics to a temporary file and renames it,
+// so that the textfile collector never reads a partial file.
+func writeMetricsTextfile(path string, m *qsgpm.Metrics) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if err := m.WritePrometheus(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+func writeSummary(path string, summary *qsgpm.RunSummary) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := summary.Encode(f, qsgpm.SummaryFormat(path)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
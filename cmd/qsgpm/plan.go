@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/urfave/cli/v2"
+)
+
+func plan(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	p, err := newPlan(c, cfg)
+	if err != nil {
+		return err
+	}
+	switch c.String("format") {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p)
+	case "text":
+		if p.IsEmpty() {
+			fmt.Println("No changes.")
+		}
+		for _, change := range p.Changes {
+			fmt.Println(change)
+		}
+		if len(p.Expirations) > 0 {
+			fmt.Println("Upcoming expirations:")
+			for _, e := range p.Expirations {
+				fmt.Printf("  %s\n", e)
+			}
+		}
+		if cfg.Approval != nil {
+			if reasons := cfg.Approval.Exceeded(p); len(reasons) > 0 {
+				fmt.Printf("Approval required: %s\n", strings.Join(reasons, ", "))
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown format %s", c.String("format"))
+}
+
+// newPlan plans the changes from the snapshot of the state flag, or from AWS.
+func newPlan(c *cli.Context, cfg *qsgpm.Config) (*qsgpm.Plan, error) {
+	if path := c.String("state"); path != "" {
+		snapshot, err := qsgpm.LoadSnapshot(path)
+		if err != nil {
+			return nil, err
+		}
+		return cfg.PlanSnapshot(snapshot)
+	}
+	app, err := newApp(c, cfg, qsgpm.WithReadOnly())
+	if err != nil {
+		return nil, err
+	}
+	defer app.Close()
+	return app.Plan(c.Context)
+}
+
+func export(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	app, err := newApp(c, cfg, qsgpm.WithReadOnly())
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+	snapshot, err := app.Export(c.Context)
+	if err != nil {
+		return err
+	}
+	output := c.String("output")
+	format := c.String("format")
+	if format == "" {
+		format = qsgpm.SnapshotFormat(output)
+	}
+	if output == "" {
+		return snapshot.Encode(os.Stdout, format)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := snapshot.Encode(f, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/urfave/cli/v2"
+)
+
+func serve(c *cli.Context) error {
+	optFns := []func(*qsgpm.ServerOptions) error{
+		qsgpm.WithInterval(c.Duration("interval")),
+		qsgpm.WithReloadInterval(c.Duration("reload-interval")),
+		qsgpm.WithScheduledDryRun(c.Bool("dry-run")),
+	}
+	if c.IsSet("jitter") {
+		optFns = append(optFns, qsgpm.WithJitter(c.Duration("jitter")))
+	}
+	s, err := newServer(c, optFns...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	srv := &http.Server{
+		Addr:              c.String("listen"),
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	ctx, cancel := context.WithCancel(c.Context)
+	defer cancel()
+	var listenErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		log.Printf("[info] listening on %s", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			listenErr = err
+			cancel()
+		}
+	}()
+	err = s.Run(ctx)
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+	if serr := srv.Shutdown(shutdownCtx); serr != nil {
+		err = errors.Join(err, serr)
+	}
+	<-done
+	return errors.Join(err, listenErr)
+}
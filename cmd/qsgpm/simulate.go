@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/mashiike/qsgpm"
+	"github.com/urfave/cli/v2"
+)
+
+func simulate(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	var users []*qsgpm.User
+	switch {
+	case c.String("users") != "" && c.String("state") != "":
+		return errors.New("-users and -state are exclusive")
+	case c.String("users") != "":
+		users, err = qsgpm.LoadUsers(c.String("users"))
+	case c.String("state") != "":
+		var snapshot *qsgpm.Snapshot
+		snapshot, err = qsgpm.LoadSnapshot(c.String("state"))
+		if err == nil {
+			users = snapshot.Users()
+		}
+	default:
+		return errors.New("-users or -state is required")
+	}
+	if err != nil {
+		return err
+	}
+	results := cfg.Simulate(users)
+	switch c.String("format") {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	case "text":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAMESPACE\tUSER\tGROUPS\tGROUPS RULE\tCUSTOM PERMISSION\tCUSTOM PERMISSION RULE")
+		for _, r := range results {
+			if r.Ignored {
+				fmt.Fprintf(w, "%s\t%s\t(ignored)\t-\t-\t-\n", r.Namespace, r.UserName)
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				r.Namespace,
+				r.UserName,
+				orDash(strings.Join(r.Groups, ",")),
+				orDash(joinNonEmpty(append(append([]string{r.GroupsRule}, r.Exceptions...), r.Override))),
+				orDash(aws.ToString(r.CustomPermission)),
+				orDash(r.CustomPermissionRule),
+			)
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown format %s", c.String("format"))
+}
+
+func orDash(str string) string {
+	if str == "" {
+		return "-"
+	}
+	return str
+}
+
+// joinNonEmpty joins the non-empty strings with commas.
+func joinNonEmpty(strs []string) string {
+	nonEmpty := make([]string, 0, len(strs))
+	for _, str := range strs {
+		if str != "" {
+			nonEmpty = append(nonEmpty, str)
+		}
+	}
+	return strings.Join(nonEmpty, ",")
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/urfave/cli/v2"
+)
+
+func validate(c *cli.Context) error {
+	paths := configPaths(c)
+	err := qsgpm.ValidateConfig(paths...)
+	if err == nil {
+		fmt.Fprintln(os.Stdout, "config is valid")
+		return nil
+	}
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	for _, e := range errs {
+		fmt.Fprintln(os.Stdout, e)
+	}
+	return fmt.Errorf("%d problem(s) found in config", len(errs))
+}
+
+func schema(c *cli.Context) error {
+	bs, err := json.MarshalIndent(qsgpm.GenerateSchema(), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, string(bs))
+	return nil
+}
@@ -1,90 +1,332 @@
 package qsgpm
 
 import (
+	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"log"
+	"net/url"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/quicksight/types"
 	gv "github.com/hashicorp/go-version"
-	gc "github.com/kayac/go-config"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
 	RequiredVersion string `yaml:"required_version"`
 
-	CreateOnly       bool          `yaml:"create_only"`
-	User             *UserConfig   `yaml:"user"`
-	Groups           []string      `yaml:"groups"`
-	CustomPermission string        `yaml:"custom_permission"`
-	Rules            []*RuleConfig `yaml:"rules"`
+	CreateOnly       bool                        `yaml:"create_only"`
+	User             *UserConfig                 `yaml:"user"`
+	Groups           []string                    `yaml:"groups"`
+	CustomPermission string                      `yaml:"custom_permission"`
+	Rules            []*RuleConfig               `yaml:"rules"`
+	Namespaces       map[string]*NamespaceConfig `yaml:"namespaces"`
+	Targets          []*TargetConfig             `yaml:"targets"`
+	AWS              *AWSConfig                  `yaml:"aws"`
+	Audit            *AuditConfig                `yaml:"audit"`
+	Notifications    []*NotificationConfig       `yaml:"notifications"`
+	Approval         *ApprovalConfig             `yaml:"approval"`
+	Exceptions       []*ExceptionConfig          `yaml:"exceptions"`
+	Overrides        map[string]*OverrideConfig  `yaml:"overrides"`
+	IgnoreUsers      []string                    `yaml:"ignore_users"`
+	IgnoreGroups     []string                    `yaml:"ignore_groups"`
+	GroupHierarchy   map[string][]string         `yaml:"group_hierarchy"`
 
 	versionConstraints gv.Constraints
+	ignore             *ignoreList
+	hierarchy          groupHierarchy
+	hash               string
+}
+
+// Load loads config files, and merges them in order.
+// Each file can include other files by the include (or extends) directive.
+// A path can also be - for stdin, or an http(s) URL.
+func (cfg *Config) Load(paths ...string) error {
+	sources, err := NewConfigSources(paths...)
+	if err != nil {
+		return err
+	}
+	return cfg.LoadSources(context.Background(), sources...)
 }
 
-func (cfg *Config) Load(path string) error {
-	if path == "" {
+// LoadSources loads config files from the sources, and merges them in order.
+func (cfg *Config) LoadSources(ctx context.Context, sources ...ConfigSource) error {
+	if len(sources) == 0 {
 		return errors.New("no config")
 	}
-	if err := gc.LoadWithEnv(cfg, path); err != nil {
+	bs, err := loadLayers(ctx, sources)
+	if err != nil {
 		return err
 	}
+	// the templates are expanded once for each file by loadLayers, so the merged YAML is decoded as is.
+	if err := yaml.Unmarshal(bs, cfg); err != nil {
+		return fmt.Errorf("parse failed: %w", err)
+	}
+	cfg.hash = fmt.Sprintf("sha256:%x", sha256.Sum256(bs))
 	return cfg.Restrict()
 }
 
+// Hash returns the SHA-256 hash of the merged config files, recorded in the audit log.
+// It is empty if the config is not loaded from files.
+func (cfg *Config) Hash() string {
+	return cfg.hash
+}
+
+// Restrict validates the config and applies the defaults to the rules.
+// It reports all errors found, not just the first one.
 func (cfg *Config) Restrict() error {
+	errs := make([]error, 0)
 	if cfg.RequiredVersion != "" {
 		constraints, err := gv.NewConstraint(cfg.RequiredVersion)
 		if err != nil {
-			return fmt.Errorf("required_version has invalid format: %w", err)
+			errs = append(errs, wrapConfigError("required_version", fmt.Errorf("required_version has invalid format: %w", err)))
 		}
 		cfg.versionConstraints = constraints
 	}
+	if cfg.User == nil {
+		cfg.User = &UserConfig{}
+	}
+	// the errors of the user are reported by the first rule which inherits it, if any.
+	if len(cfg.Rules) == 0 {
+		if err := cfg.User.Restrict(); err != nil {
+			errs = append(errs, wrapConfigError("user", err))
+		}
+	}
+	if cfg.AWS == nil {
+		cfg.AWS = &AWSConfig{}
+	}
+	if err := cfg.AWS.Restrict(); err != nil {
+		errs = append(errs, wrapConfigError("aws", err))
+	}
+	if cfg.Audit == nil {
+		cfg.Audit = &AuditConfig{}
+	}
+	if err := cfg.Audit.Restrict(); err != nil {
+		errs = append(errs, wrapConfigError("audit", err))
+	}
+	for i, notification := range cfg.Notifications {
+		if err := notification.Restrict(); err != nil {
+			errs = append(errs, wrapConfigError(fmt.Sprintf("notifications[%d]", i), err))
+		}
+	}
+	if cfg.Approval != nil {
+		if err := cfg.Approval.Restrict(); err != nil {
+			errs = append(errs, wrapConfigError("approval", err))
+		}
+	}
+	for i, exception := range cfg.Exceptions {
+		exception.label = fmt.Sprintf("exceptions[%d]", i)
+		if err := exception.Restrict(); err != nil {
+			errs = append(errs, wrapConfigError(exception.label, err))
+		}
+	}
+	if ignore, err := newIgnoreList(cfg.IgnoreUsers, cfg.IgnoreGroups); err != nil {
+		errs = append(errs, err)
+	} else {
+		cfg.ignore = ignore
+	}
+	if hierarchy, err := newGroupHierarchy(cfg.GroupHierarchy); err != nil {
+		errs = append(errs, wrapConfigError("group_hierarchy", err))
+	} else {
+		cfg.hierarchy = hierarchy
+	}
+	overrides := make([]string, 0, len(cfg.Overrides))
+	for key := range cfg.Overrides {
+		overrides = append(overrides, key)
+	}
+	sort.Strings(overrides)
+	for _, key := range overrides {
+		o := cfg.Overrides[key]
+		if o == nil {
+			o = &OverrideConfig{}
+			cfg.Overrides[key] = o
+		}
+		if err := o.Restrict(key); err != nil {
+			errs = append(errs, wrapConfigError(o.String(), err))
+		}
+	}
+	ids := make(map[string]string)
+	inherited := make(map[string]bool)
 	for i, rule := range cfg.Rules {
-		rule.User = rule.User.Merge(cfg.User)
-		rule.Groups = append(rule.Groups, cfg.Groups...)
-		rule.CustomPermission = coalesceString(rule.CustomPermission, cfg.CustomPermission)
-		if err := rule.Restrict(); err != nil {
-			return fmt.Errorf("rules[%d]: %w", i, err)
+		rule.label = fmt.Sprintf("rules[%d]", i)
+		own, err := rule.inherit(cfg.User, cfg.Groups, cfg.CustomPermission)
+		if own != nil {
+			errs = append(errs, wrapConfigError(rule.errorLabel(i), own))
+		} else if err != nil && !inherited[err.Error()] {
+			inherited[err.Error()] = true
+			errs = append(errs, wrapConfigError(rule.errorLabel(i), err))
 		}
+		if err := checkDuplicateRuleID(ids, rule); err != nil {
+			errs = append(errs, wrapConfigError(rule.errorLabel(i), err))
+		}
+	}
+	namespaces := make([]string, 0, len(cfg.Namespaces))
+	for namespace := range cfg.Namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		ns := cfg.Namespaces[namespace]
+		if ns == nil {
+			ns = &NamespaceConfig{}
+			cfg.Namespaces[namespace] = ns
+		}
+		label := fmt.Sprintf("namespaces[%s]", namespace)
+		if err := ns.Restrict(namespace, cfg); err != nil {
+			errs = append(errs, wrapConfigError(label, err))
+		}
+		for i, rule := range ns.Rules {
+			if err := checkDuplicateRuleID(ids, rule); err != nil {
+				errs = append(errs, wrapConfigError(label, wrapConfigError(rule.errorLabel(i), err)))
+			}
+		}
+	}
+	names := make(map[string]int, len(cfg.Targets))
+	for i, target := range cfg.Targets {
+		label := fmt.Sprintf("targets[%d]", i)
+		if err := target.Restrict(); err != nil {
+			errs = append(errs, wrapConfigError(label, err))
+			continue
+		}
+		if j, ok := names[target.String()]; ok {
+			errs = append(errs, wrapConfigError(label, fmt.Errorf("duplicate target %s with targets[%d]", target, j)))
+		}
+		names[target.String()] = i
+	}
+	return errors.Join(errs...)
+}
+
+// getRules returns the rules evaluated for users in the namespace.
+// Rules of the namespace section take priority over top-level rules.
+func (cfg *Config) getRules(namespace string) []*RuleConfig {
+	if ns, ok := cfg.Namespaces[namespace]; ok {
+		return ns.rules
+	}
+	return cfg.Rules
+}
+
+func checkDuplicateRuleID(ids map[string]string, rule *RuleConfig) error {
+	if rule.ID == "" {
+		return nil
 	}
+	if label, ok := ids[rule.ID]; ok {
+		return fmt.Errorf("duplicate id %s with %s", rule.ID, label)
+	}
+	ids[rule.ID] = rule.label
 	return nil
 }
 
+// GetCustomPermissionName returns the custom permission pinned by the override,
+// or the custom permission of the first matching rule for the user.
 func (cfg *Config) GetCustomPermissionName(user *User) *string {
-	for _, rule := range cfg.Rules {
-		if name, ok := rule.GetCustomPermissionName(user); ok {
-			return &name
-		}
+	if o, ok := cfg.matchOverride(user); ok && o.CustomPermission != "" {
+		return &o.CustomPermission
+	}
+	if rule, ok := cfg.MatchCustomPermissionRule(user); ok {
+		return &rule.CustomPermission
 	}
 	return nil
 }
 
+// MatchCustomPermissionRule returns the first rule which gives the user a custom permission.
+// Expired rules are skipped.
+func (cfg *Config) MatchCustomPermissionRule(user *User) (*RuleConfig, bool) {
+	now := time.Now()
+	for _, rule := range cfg.getRules(user.Namespace) {
+		if rule.expired(now) {
+			continue
+		}
+		if _, ok := rule.GetCustomPermissionName(user); ok {
+			return rule, true
+		}
+	}
+	return nil, false
+}
+
+// GetGroupNames returns the groups of the first matching rule, the groups of the active exceptions
+// and the groups added by the override for the user, with their ancestors in group_hierarchy,
+// except the groups removed by the override.
 func (cfg *Config) GetGroupNames(user *User) ([]string, bool) {
-	for _, rule := range cfg.Rules {
-		if !rule.User.Match(user) {
+	groups, ok := cfg.getGroupNames(user)
+	o, overridden := cfg.matchOverride(user)
+	if overridden {
+		groups, ok = o.addGroups(groups), true
+	}
+	if !ok {
+		return nil, false
+	}
+	groups = cfg.hierarchy.expandNames(groups)
+	if overridden {
+		// removals are applied after the expansion, so that a parent group can be removed.
+		groups = o.removeGroups(groups)
+	}
+	return groups, true
+}
+
+func (cfg *Config) getGroupNames(user *User) ([]string, bool) {
+	rule, ok := cfg.MatchGroupRule(user)
+	exceptions := cfg.activeExceptions(user)
+	if len(exceptions) == 0 {
+		if ok {
+			return rule.Groups, true
+		}
+		return nil, false
+	}
+	groups := make([]string, 0)
+	if ok {
+		groups = append(groups, rule.Groups...)
+	}
+	for _, e := range exceptions {
+		for _, g := range e.Groups {
+			if !containsString(groups, g) {
+				groups = append(groups, g)
+			}
+		}
+	}
+	return groups, true
+}
+
+// expectGroupNames returns the groups to which the user should belong.
+func (cfg *Config) expectGroupNames(user *User) []string {
+	groups, _ := cfg.GetGroupNames(user)
+	return groups
+}
+
+// MatchGroupRule returns the first rule which gives the user groups.
+// Expired rules are skipped.
+func (cfg *Config) MatchGroupRule(user *User) (*RuleConfig, bool) {
+	now := time.Now()
+	for _, rule := range cfg.getRules(user.Namespace) {
+		if rule.expired(now) || !rule.User.Match(user) {
 			continue
 		}
-		if groups, ok := rule.GetGroupNames(user); ok {
-			return groups, true
+		if _, ok := rule.GetGroupNames(user); ok {
+			return rule, true
 		}
 	}
 	return nil, false
 }
 
 func (cfg *Config) GetNamespaces() []string {
-	m := make(map[string]struct{}, 1+len(cfg.Rules))
+	m := make(map[string]struct{}, 1+len(cfg.Rules)+len(cfg.Namespaces))
 	m[strings.TrimSpace(cfg.User.Namespace)] = struct{}{}
 	for _, rule := range cfg.Rules {
 		m[strings.TrimSpace(rule.User.Namespace)] = struct{}{}
 	}
+	for namespace := range cfg.Namespaces {
+		m[strings.TrimSpace(namespace)] = struct{}{}
+	}
 	namespaces := make([]string, 0, len(m))
 	for namespace := range m {
 		if len(namespace) != 0 {
 			namespaces = append(namespaces, namespace)
 		}
 	}
+	sort.Strings(namespaces)
 	return namespaces
 }
 
@@ -107,16 +349,234 @@ func (c *Config) ValidateVersion(version string) error {
 	return nil
 }
 
+// NamespaceConfig is the rule set for a single namespace.
+// The user defaults, groups and custom_permission override the top-level ones,
+// and the rules are evaluated before the top-level rules.
+type NamespaceConfig struct {
+	User             *UserConfig   `yaml:"user"`
+	Groups           []string      `yaml:"groups"`
+	CustomPermission string        `yaml:"custom_permission"`
+	Rules            []*RuleConfig `yaml:"rules"`
+
+	rules []*RuleConfig
+}
+
+func (cfg *NamespaceConfig) Restrict(namespace string, parent *Config) error {
+	errs := make([]error, 0)
+	if cfg.User == nil {
+		cfg.User = &UserConfig{}
+	}
+	if cfg.User.Namespace != "" && cfg.User.Namespace != namespace {
+		errs = append(errs, wrapConfigError("user", fmt.Errorf("namespace %s does not match namespace section %s", cfg.User.Namespace, namespace)))
+	}
+	if err := cfg.User.Restrict(); err != nil {
+		errs = append(errs, wrapConfigError("user", err))
+	}
+	cfg.User = cfg.User.Merge(parent.User)
+	cfg.User.Namespace = namespace
+	if cfg.Groups == nil {
+		cfg.Groups = parent.Groups
+	}
+	cfg.CustomPermission = coalesceString(cfg.CustomPermission, parent.CustomPermission)
+	cfg.rules = make([]*RuleConfig, 0, len(cfg.Rules)+len(parent.Rules))
+	for i, rule := range cfg.Rules {
+		rule.label = fmt.Sprintf("namespaces[%s].rules[%d]", namespace, i)
+		if rule.User != nil && rule.User.Namespace != "" && rule.User.Namespace != namespace {
+			errs = append(errs, wrapConfigError(rule.errorLabel(i), wrapConfigError("user", fmt.Errorf("namespace %s does not match namespace section %s", rule.User.Namespace, namespace))))
+			continue
+		}
+		// the errors of the inherited settings are reported by the namespace section or the top level.
+		if own, _ := rule.inherit(cfg.User, cfg.Groups, cfg.CustomPermission); own != nil {
+			errs = append(errs, wrapConfigError(rule.errorLabel(i), own))
+		}
+		cfg.rules = append(cfg.rules, rule)
+	}
+	cfg.rules = append(cfg.rules, parent.Rules...)
+	return errors.Join(errs...)
+}
+
+// AWSConfig is the configuration of AWS credentials and endpoint.
+type AWSConfig struct {
+	Profile       string `yaml:"profile"`
+	Region        string `yaml:"region"`
+	AssumeRoleARN string `yaml:"assume_role_arn"`
+	ExternalID    string `yaml:"external_id"`
+	Endpoint      string `yaml:"endpoint"`
+	AccountID     string `yaml:"account_id"`
+}
+
+func (cfg *AWSConfig) Restrict() error {
+	if cfg.AccountID != "" && !isAWSAccountID(cfg.AccountID) {
+		return fmt.Errorf("account_id %s is not a 12-digit AWS account ID", cfg.AccountID)
+	}
+	if cfg.AssumeRoleARN != "" && !strings.HasPrefix(cfg.AssumeRoleARN, "arn:") {
+		return fmt.Errorf("assume_role_arn %s is not an ARN", cfg.AssumeRoleARN)
+	}
+	if cfg.ExternalID != "" && cfg.AssumeRoleARN == "" {
+		return errors.New("external_id requires assume_role_arn")
+	}
+	if cfg.Endpoint != "" {
+		u, err := url.Parse(cfg.Endpoint)
+		if err != nil {
+			return fmt.Errorf("endpoint: %w", err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("endpoint %s is not an absolute URL", cfg.Endpoint)
+		}
+	}
+	return nil
+}
+
+func (cfg *AWSConfig) Clone() *AWSConfig {
+	cloned := *cfg
+	return &cloned
+}
+
+// Merge returns the config whose fields take priority over other.
+// assume_role_arn and external_id are merged as a pair, so the external ID of other is not used for the role of cfg.
+func (cfg *AWSConfig) Merge(other *AWSConfig) *AWSConfig {
+	cloned := cfg.Clone()
+	if other == nil {
+		return cloned
+	}
+	cloned.Profile = coalesceString(cfg.Profile, other.Profile)
+	cloned.Region = coalesceString(cfg.Region, other.Region)
+	if cfg.AssumeRoleARN == "" {
+		cloned.AssumeRoleARN = other.AssumeRoleARN
+		cloned.ExternalID = coalesceString(cfg.ExternalID, other.ExternalID)
+	}
+	cloned.Endpoint = coalesceString(cfg.Endpoint, other.Endpoint)
+	cloned.AccountID = coalesceString(cfg.AccountID, other.AccountID)
+	return cloned
+}
+
+// TargetConfig is an AWS account and region to which the config is applied.
+type TargetConfig struct {
+	Name       string   `yaml:"name"`
+	AccountID  string   `yaml:"account_id"`
+	Region     string   `yaml:"region"`
+	RoleARN    string   `yaml:"role_arn"`
+	ExternalID string   `yaml:"external_id"`
+	CreateOnly *bool    `yaml:"create_only"`
+	Namespaces []string `yaml:"namespaces"`
+}
+
+func (cfg *TargetConfig) Restrict() error {
+	if cfg.AccountID != "" && !isAWSAccountID(cfg.AccountID) {
+		return fmt.Errorf("account_id %s is not a 12-digit AWS account ID", cfg.AccountID)
+	}
+	if cfg.RoleARN != "" && !strings.HasPrefix(cfg.RoleARN, "arn:") {
+		return fmt.Errorf("role_arn %s is not an ARN", cfg.RoleARN)
+	}
+	if cfg.ExternalID != "" && cfg.RoleARN == "" {
+		return errors.New("external_id requires role_arn")
+	}
+	if cfg.Name == "" && cfg.AccountID == "" && cfg.Region == "" && cfg.RoleARN == "" {
+		return errors.New("target is empty")
+	}
+	return nil
+}
+
+// String returns the name of the target, used in logs and reports.
+func (cfg *TargetConfig) String() string {
+	if cfg.Name != "" {
+		return cfg.Name
+	}
+	parts := make([]string, 0, 3)
+	for _, part := range []string{cfg.AccountID, cfg.Region, cfg.RoleARN} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// GetNamespaces returns the namespaces managed in the target.
+func (cfg *TargetConfig) GetNamespaces(namespaces []string) []string {
+	if len(cfg.Namespaces) == 0 {
+		return namespaces
+	}
+	allowed := make(map[string]struct{}, len(cfg.Namespaces))
+	for _, namespace := range cfg.Namespaces {
+		allowed[namespace] = struct{}{}
+	}
+	filtered := make([]string, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		if _, ok := allowed[namespace]; ok {
+			filtered = append(filtered, namespace)
+		}
+	}
+	return filtered
+}
+
+func isAWSAccountID(str string) bool {
+	if len(str) != 12 {
+		return false
+	}
+	for _, r := range str {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 type RuleConfig struct {
+	ID               string      `yaml:"id"`
+	Description      string      `yaml:"description"`
 	User             *UserConfig `yaml:"user"`
 	Groups           []string    `yaml:"groups"`
 	CustomPermission string      `yaml:"custom_permission"`
+	// ExpiresAt is the time after which the rule no longer matches.
+	ExpiresAt string `yaml:"expires_at"`
+
+	label     string
+	expiresAt time.Time
 }
 
-func (cfg *RuleConfig) Restrict() error {
+// String returns the id of the rule, or the position in the config if the id is empty.
+func (cfg *RuleConfig) String() string {
+	if cfg.ID != "" {
+		return cfg.ID
+	}
+	return cfg.label
+}
+
+func (cfg *RuleConfig) errorLabel(i int) string {
+	if cfg.ID != "" {
+		return fmt.Sprintf("rules[%s]", cfg.ID)
+	}
+	return fmt.Sprintf("rules[%d]", i)
+}
+
+// inherit applies the settings of the parent to the rule.
+// own is the error of the settings written in the rule, and err is the error of the rule with the inherited settings.
+func (cfg *RuleConfig) inherit(user *UserConfig, groups []string, customPermission string) (own error, err error) {
+	if cfg.User == nil {
+		cfg.User = &UserConfig{}
+	}
 	if err := cfg.User.Restrict(); err != nil {
-		return fmt.Errorf("user: %w", err)
+		return wrapConfigError("user", err), nil
 	}
+	if cfg.ExpiresAt != "" {
+		t, err := parseExpiresAt(cfg.ExpiresAt)
+		if err != nil {
+			return err, nil
+		}
+		cfg.expiresAt = t
+	}
+	cfg.User = cfg.User.Merge(user)
+	cfg.Groups = append(cfg.Groups, groups...)
+	cfg.CustomPermission = coalesceString(cfg.CustomPermission, customPermission)
+	return nil, cfg.Restrict()
+}
+
+// expired reports whether the rule has expired at now.
+func (cfg *RuleConfig) expired(now time.Time) bool {
+	return !cfg.expiresAt.IsZero() && !now.Before(cfg.expiresAt)
+}
+
+func (cfg *RuleConfig) Restrict() error {
 	groups := make(map[string]struct{}, len(cfg.Groups))
 	for _, group := range cfg.Groups {
 		groups[group] = struct{}{}
@@ -125,6 +585,9 @@ func (cfg *RuleConfig) Restrict() error {
 	for group := range groups {
 		cfg.Groups = append(cfg.Groups, group)
 	}
+	if err := cfg.User.Restrict(); err != nil {
+		return wrapConfigError("user", err)
+	}
 	return nil
 }
 
@@ -160,6 +623,15 @@ type UserConfig struct {
 	role         types.UserRole
 }
 
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
 func coalesceString(strs ...string) string {
 	for _, str := range strs {
 		if str != "" {
@@ -186,17 +658,18 @@ func (cfg *UserConfig) Merge(other *UserConfig) *UserConfig {
 }
 
 func (cfg *UserConfig) Restrict() error {
+	errs := make([]error, 0)
 	if cfg.IdentityType != "" {
 		if err := cfg.validateIdentityType(); err != nil {
-			return err
+			errs = append(errs, err)
 		}
 	}
 	if cfg.Role != "" {
 		if err := cfg.validateRole(); err != nil {
-			return err
+			errs = append(errs, err)
 		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 func (cfg *UserConfig) validateIdentityType() error {
@@ -269,6 +742,40 @@ func (cfg *UserConfig) Match(user *User) bool {
 	return true
 }
 
+// configError is an error at a path of the config, such as rules[1] or user.
+type configError struct {
+	path string
+	err  error
+}
+
+func (e *configError) Error() string {
+	return e.path + ": " + e.err.Error()
+}
+
+func (e *configError) Unwrap() error {
+	return e.err
+}
+
+// wrapConfigError prefixes the path to the error.
+// If the error is joined, each of the errors is prefixed.
+func wrapConfigError(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		wrapped := make([]error, 0, len(errs))
+		for _, e := range errs {
+			wrapped = append(wrapped, wrapConfigError(path, e))
+		}
+		return errors.Join(wrapped...)
+	}
+	return &configError{
+		path: path,
+		err:  err,
+	}
+}
+
 func NewDefaultConfig() *Config {
 	return &Config{}
 }
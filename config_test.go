@@ -2,6 +2,8 @@ package qsgpm_test
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -14,6 +16,10 @@ func TestConfigValid(t *testing.T) {
 	cases := []string{
 		"testdata/config.yaml",
 		"testdata/config_quicksight.yaml",
+		"testdata/config_namespaces.yaml",
+		"testdata/config_targets.yaml",
+		"testdata/config_aws.yaml",
+		"testdata/config_audit.yaml",
 	}
 	for _, cfgFile := range cases {
 		t.Run(cfgFile, func(t *testing.T) {
@@ -34,8 +40,69 @@ func TestConfigInvalid(t *testing.T) {
 			excpected: "rules[0]: user: given IdentityType: Hoge is not one of IAM or QUICKSIGHT",
 		},
 		{
-			filepath:  "testdata/role_invalid.yaml",
-			excpected: "rules[1]: user: given Role: Auther is not one of ADMIN, AUTHOR, READER, RESTRICTED_AUTHOR or RESTRICTED_READER",
+			filepath: "testdata/role_invalid.yaml",
+			excpected: "rules[1]: user: given Role: Auther is not one of ADMIN, AUTHOR, READER, RESTRICTED_AUTHOR or RESTRICTED_READER\n" +
+				"rules[2]: user: given Role: Auther is not one of ADMIN, AUTHOR, READER, RESTRICTED_AUTHOR or RESTRICTED_READER",
+		},
+		{
+			filepath:  "testdata/namespace_mismatch.yaml",
+			excpected: "namespaces[tenant-a]: rules[0]: user: namespace tenant-b does not match namespace section tenant-a",
+		},
+		{
+			filepath:  "testdata/target_invalid.yaml",
+			excpected: "targets[1]: account_id 1234 is not a 12-digit AWS account ID",
+		},
+		{
+			filepath:  "testdata/aws_invalid.yaml",
+			excpected: "aws: endpoint localhost:4566 is not an absolute URL",
+		},
+		{
+			filepath: "testdata/audit_invalid.yaml",
+			excpected: "audit: sinks[0]: path is required for the file sink\n" +
+				"audit: sinks[1]: type syslog is not one of file or stdout",
+		},
+		{
+			filepath: "testdata/notification_invalid.yaml",
+			excpected: "notifications[0]: url hooks.slack.com/services/XXX is not an http(s) URL\n" +
+				"notifications[0]: preset discord is not one of generic, slack or teams\n" +
+				"notifications[0]: event success is not one of changes, drift or failure\n" +
+				"notifications[0]: template: unknown variable ${changes}\n" +
+				"notifications[0]: min_changes must not be negative",
+		},
+		{
+			filepath: "testdata/approval_invalid.yaml",
+			excpected: "approval: max_group_deletions must not be negative\n" +
+				"approval: max_membership_removals must not be negative",
+		},
+		{
+			filepath: "testdata/exception_invalid.yaml",
+			excpected: "exceptions[0]: only one of user_name or email is allowed\n" +
+				"exceptions[0]: groups are required\n" +
+				"exceptions[0]: expires_at tomorrow is not an RFC 3339 time or a date such as 2006-01-02",
+		},
+		{
+			filepath: "testdata/override_invalid.yaml",
+			excpected: "overrides[hoge@example.com]: add_groups, remove_groups or custom_permission is required\n" +
+				"overrides[tora]: reason is required\n" +
+				"overrides[tora]: group legacy is in both add_groups and remove_groups",
+		},
+		{
+			filepath: "testdata/ignore_invalid.yaml",
+			excpected: "ignore_users[1]: empty pattern\n" +
+				"ignore_groups[0]: empty pattern",
+		},
+		{
+			filepath: "testdata/group_hierarchy_cycle.yaml",
+			excpected: "group_hierarchy: cycle eng -> eng-data -> eng-data-ml -> eng\n" +
+				"group_hierarchy: cycle ops -> ops",
+		},
+		{
+			filepath:  "testdata/rule_id_duplicate.yaml",
+			excpected: "namespaces[tenant-a]: rules[reader]: duplicate id reader with rules[0]",
+		},
+		{
+			filepath:  "testdata/rule_id_invalid.yaml",
+			excpected: "rules[reader]: user: given Role: Reeder is not one of ADMIN, AUTHOR, READER, RESTRICTED_AUTHOR or RESTRICTED_READER",
 		},
 	}
 	for _, c := range cases {
@@ -167,3 +234,275 @@ func TestConfigGroups(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigNamespaces(t *testing.T) {
+	cfg := qsgpm.NewDefaultConfig()
+	err := cfg.Load("testdata/config_namespaces.yaml")
+	require.NoError(t, err)
+	require.Equal(t, []string{"default", "tenant-a", "tenant-b"}, cfg.GetNamespaces())
+
+	cases := []struct {
+		user                      *qsgpm.User
+		excpectedGroups           []string
+		excpectedCustomPermission *string
+	}{
+		{
+			user: &qsgpm.User{
+				User: types.User{
+					Email:        aws.String("admin@example.com"),
+					UserName:     aws.String("Developer/admin@example.com"),
+					IdentityType: types.IdentityTypeIam,
+					Role:         types.UserRoleAdmin,
+				},
+				Namespace: "default",
+			},
+			excpectedGroups: []string{"all", "admins"},
+		},
+		{
+			user: &qsgpm.User{
+				User: types.User{
+					Email:        aws.String("hoge@tenant-a.example.com"),
+					UserName:     aws.String("Tenant/hoge@tenant-a.example.com"),
+					IdentityType: types.IdentityTypeIam,
+					Role:         types.UserRoleAuthor,
+				},
+				Namespace: "tenant-a",
+			},
+			excpectedGroups:           []string{"tenant-a", "tenant-a-authors"},
+			excpectedCustomPermission: aws.String("tenant-a-author"),
+		},
+		{
+			user: &qsgpm.User{
+				User: types.User{
+					Email:        aws.String("piyo@tenant-a.example.com"),
+					UserName:     aws.String("Tenant/piyo@tenant-a.example.com"),
+					IdentityType: types.IdentityTypeIam,
+					Role:         types.UserRoleReader,
+				},
+				Namespace: "tenant-a",
+			},
+			excpectedGroups:           []string{"tenant-a", "tenant-a-readers"},
+			excpectedCustomPermission: aws.String("tenant-a-default"),
+		},
+		{
+			user: &qsgpm.User{
+				User: types.User{
+					Email:        aws.String("piyo@other.example.com"),
+					UserName:     aws.String("Tenant/piyo@other.example.com"),
+					IdentityType: types.IdentityTypeIam,
+					Role:         types.UserRoleReader,
+				},
+				Namespace: "tenant-a",
+			},
+			excpectedGroups: nil,
+		},
+		{
+			user: &qsgpm.User{
+				User: types.User{
+					Email:        aws.String("fuga@example.com"),
+					UserName:     aws.String("fuga"),
+					IdentityType: types.IdentityTypeQuicksight,
+					Role:         types.UserRoleReader,
+				},
+				Namespace: "tenant-b",
+			},
+			excpectedGroups: []string{"all", "tenant-b-users"},
+		},
+	}
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case.%d", i), func(t *testing.T) {
+			actual, ok := cfg.GetGroupNames(c.user)
+			require.Equal(t, c.excpectedGroups != nil, ok)
+			require.ElementsMatch(t, c.excpectedGroups, actual)
+			require.Equal(t, c.excpectedCustomPermission, cfg.GetCustomPermissionName(c.user))
+		})
+	}
+}
+
+func TestConfigTargets(t *testing.T) {
+	cfg := qsgpm.NewDefaultConfig()
+	err := cfg.Load("testdata/config_targets.yaml")
+	require.NoError(t, err)
+	require.Len(t, cfg.Targets, 2)
+	require.Equal(t, "production", cfg.Targets[0].String())
+	require.Equal(t, "210987654321/us-east-1", cfg.Targets[1].String())
+	require.Equal(t, []string{"default"}, cfg.Targets[1].GetNamespaces([]string{"default", "tenant-a"}))
+	require.Equal(t, []string{"default", "tenant-a"}, cfg.Targets[0].GetNamespaces([]string{"default", "tenant-a"}))
+}
+
+func TestAWSConfigMerge(t *testing.T) {
+	cfg := qsgpm.NewDefaultConfig()
+	err := cfg.Load("testdata/config_aws.yaml")
+	require.NoError(t, err)
+
+	override := &qsgpm.AWSConfig{
+		Region:    "us-east-1",
+		AccountID: "210987654321",
+	}
+	actual := override.Merge(cfg.AWS)
+	require.Equal(t, &qsgpm.AWSConfig{
+		Profile:       "qsgpm",
+		Region:        "us-east-1",
+		AssumeRoleARN: "arn:aws:iam::123456789012:role/qsgpm",
+		ExternalID:    "qsgpm",
+		Endpoint:      "http://localhost:4566",
+		AccountID:     "210987654321",
+	}, actual)
+}
+
+func TestConfigLayers(t *testing.T) {
+	author := &qsgpm.User{
+		User: types.User{
+			Email:        aws.String("hoge@staging.example.com"),
+			UserName:     aws.String("Developer/hoge@staging.example.com"),
+			IdentityType: types.IdentityTypeIam,
+			Role:         types.UserRoleAuthor,
+		},
+		Namespace: "default",
+	}
+	admin := &qsgpm.User{
+		User: types.User{
+			Email:        aws.String("admin@example.com"),
+			UserName:     aws.String("Developer/admin@example.com"),
+			IdentityType: types.IdentityTypeIam,
+			Role:         types.UserRoleAdmin,
+		},
+		Namespace: "default",
+	}
+	cases := []struct {
+		paths               []string
+		excpectedRules      int
+		excpectedCreateOnly bool
+		user                *qsgpm.User
+		excpectedGroups     []string
+	}{
+		{
+			paths:           []string{"testdata/layers/staging.yaml"},
+			excpectedRules:  3,
+			user:            author,
+			excpectedGroups: []string{"all", "staging_authors"},
+		},
+		{
+			paths:               []string{"testdata/layers/production.yaml"},
+			excpectedRules:      3,
+			excpectedCreateOnly: true,
+			user:                author,
+			excpectedGroups:     []string{"all", "authors"},
+		},
+		{
+			paths:               []string{"testdata/layers/production.yaml", "testdata/layers/staging.yaml"},
+			excpectedRules:      4,
+			excpectedCreateOnly: true,
+			user:                author,
+			excpectedGroups:     []string{"all", "staging_authors"},
+		},
+		{
+			paths:           []string{"testdata/layers/base.yaml", "testdata/layers/replace.yaml"},
+			excpectedRules:  1,
+			user:            admin,
+			excpectedGroups: []string{"all", "administrators"},
+		},
+	}
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case.%d", i), func(t *testing.T) {
+			cfg := qsgpm.NewDefaultConfig()
+			err := cfg.Load(c.paths...)
+			require.NoError(t, err)
+			require.Len(t, cfg.Rules, c.excpectedRules)
+			require.Equal(t, c.excpectedCreateOnly, cfg.CreateOnly)
+			actual, ok := cfg.GetGroupNames(c.user)
+			require.True(t, ok)
+			require.ElementsMatch(t, c.excpectedGroups, actual)
+		})
+	}
+}
+
+func TestConfigLayersCircular(t *testing.T) {
+	cfg := qsgpm.NewDefaultConfig()
+	err := cfg.Load("testdata/layers/circular.yaml")
+	require.EqualError(t, err, "testdata/layers/circular.yaml: circular include")
+}
+
+func TestConfigLayersTemplateOnce(t *testing.T) {
+	base, err := filepath.Abs("testdata/config.yaml")
+	require.NoError(t, err)
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(`include: %q
+audit:
+  actor: '{{ env "QSGPM_TEST_ACTOR" }}'
+`, base)), 0644))
+	t.Setenv("QSGPM_TEST_ACTOR", `{{ env "HOME" }}`)
+	cfg := qsgpm.NewDefaultConfig()
+	require.NoError(t, cfg.Load(configPath))
+	require.Equal(t, `{{ env "HOME" }}`, cfg.Audit.Actor, "the value of the env is not expanded again")
+}
+
+func TestConfigRuleID(t *testing.T) {
+	cfg := qsgpm.NewDefaultConfig()
+	err := cfg.Load("testdata/layers/override_by_id.yaml")
+	require.NoError(t, err)
+	require.Len(t, cfg.Rules, 3)
+
+	cases := []struct {
+		user            *qsgpm.User
+		excpectedRule   string
+		excpectedGroups []string
+	}{
+		{
+			user: &qsgpm.User{
+				User: types.User{
+					Email:        aws.String("admin@example.com"),
+					UserName:     aws.String("Developer/admin@example.com"),
+					IdentityType: types.IdentityTypeIam,
+					Role:         types.UserRoleAdmin,
+				},
+				Namespace: "default",
+			},
+			excpectedRule:   "admin",
+			excpectedGroups: []string{"all", "admins"},
+		},
+		{
+			user: &qsgpm.User{
+				User: types.User{
+					Email:        aws.String("tora@example.com"),
+					UserName:     aws.String("Reader/tora@example.com"),
+					IdentityType: types.IdentityTypeIam,
+					Role:         types.UserRoleReader,
+				},
+				Namespace: "default",
+			},
+			excpectedRule:   "reader",
+			excpectedGroups: []string{"all", "viewers"},
+		},
+	}
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("case.%d", i), func(t *testing.T) {
+			rule, ok := cfg.MatchGroupRule(c.user)
+			require.True(t, ok)
+			require.Equal(t, c.excpectedRule, rule.String())
+			require.ElementsMatch(t, c.excpectedGroups, rule.Groups)
+		})
+	}
+
+	cfg = qsgpm.NewDefaultConfig()
+	err = cfg.Load("testdata/config.yaml")
+	require.NoError(t, err)
+	require.Equal(t, "rules[3]", cfg.Rules[3].String())
+}
+
+func TestAWSConfigMergeAssumeRole(t *testing.T) {
+	cfg := qsgpm.NewDefaultConfig()
+	err := cfg.Load("testdata/config_aws.yaml")
+	require.NoError(t, err)
+
+	role := (&qsgpm.AWSConfig{AssumeRoleARN: "arn:aws:iam::210987654321:role/qsgpm"}).Merge(cfg.AWS)
+	require.Equal(t, "arn:aws:iam::210987654321:role/qsgpm", role.AssumeRoleARN)
+	require.Empty(t, role.ExternalID, "the external ID of the config is not for the role of the flags")
+
+	externalID := (&qsgpm.AWSConfig{ExternalID: "other"}).Merge(cfg.AWS)
+	require.Equal(t, "arn:aws:iam::123456789012:role/qsgpm", externalID.AssumeRoleARN)
+	require.Equal(t, "other", externalID.ExternalID)
+	require.NoError(t, externalID.Restrict())
+
+	require.EqualError(t, (&qsgpm.AWSConfig{ExternalID: "other"}).Merge(&qsgpm.AWSConfig{}).Restrict(), "external_id requires assume_role_arn")
+}
@@ -0,0 +1,237 @@
+package qsgpm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConfigSource is where a config file is read from.
+type ConfigSource interface {
+	// Read returns the content of the config file, before expanding the templates.
+	Read(ctx context.Context) ([]byte, error)
+	// Include returns the source of a file included by the config file.
+	Include(path string) (ConfigSource, error)
+	// String returns the location of the config file.
+	String() string
+}
+
+// NewConfigSource returns the source of the location, - for stdin, an http(s) URL, or a file path.
+func NewConfigSource(location string) (ConfigSource, error) {
+	switch {
+	case location == "":
+		return nil, errors.New("no config")
+	case location == "-":
+		return NewStdinConfigSource(), nil
+	case isHTTPURL(location):
+		return NewURLConfigSource(location)
+	}
+	return NewFileConfigSource(location), nil
+}
+
+// NewConfigSources returns the sources of the locations.
+func NewConfigSources(locations ...string) ([]ConfigSource, error) {
+	sources := make([]ConfigSource, 0, len(locations))
+	for _, location := range locations {
+		src, err := NewConfigSource(location)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+func isHTTPURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+type fileConfigSource struct {
+	path string
+}
+
+// NewFileConfigSource returns the source of a local file.
+// The relative includes are resolved from the directory of the file.
+func NewFileConfigSource(path string) ConfigSource {
+	return &fileConfigSource{path: path}
+}
+
+func (s *fileConfigSource) Read(_ context.Context) ([]byte, error) {
+	return os.ReadFile(s.path)
+}
+
+func (s *fileConfigSource) Include(path string) (ConfigSource, error) {
+	if isHTTPURL(path) {
+		return NewURLConfigSource(path)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(s.path), path)
+	}
+	return NewFileConfigSource(path), nil
+}
+
+func (s *fileConfigSource) String() string {
+	return s.path
+}
+
+type stdinConfigSource struct {
+	once sync.Once
+	bs   []byte
+	err  error
+}
+
+// NewStdinConfigSource returns the source of stdin.
+// Stdin is read once, and the same content is returned on reloads.
+// The relative includes are resolved from the working directory.
+func NewStdinConfigSource() ConfigSource {
+	return &stdinConfigSource{}
+}
+
+func (s *stdinConfigSource) Read(_ context.Context) ([]byte, error) {
+	s.once.Do(func() {
+		s.bs, s.err = io.ReadAll(os.Stdin)
+	})
+	return s.bs, s.err
+}
+
+func (s *stdinConfigSource) Include(path string) (ConfigSource, error) {
+	return NewConfigSource(path)
+}
+
+func (s *stdinConfigSource) String() string {
+	return "stdin"
+}
+
+// cachedConfig is the last response of a URL, revalidated by the ETag or the Last-Modified.
+type cachedConfig struct {
+	etag         string
+	lastModified string
+	body         []byte
+}
+
+// configCache is shared by a URL source and its includes.
+type configCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedConfig
+}
+
+func (c *configCache) get(u string) *cachedConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[u]
+}
+
+func (c *configCache) set(u string, entry *cachedConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[u] = entry
+}
+
+type urlConfigSource struct {
+	u      *url.URL
+	client *http.Client
+	cache  *configCache
+}
+
+// NewURLConfigSource returns the source of an http(s) URL.
+// The response is cached, and revalidated by the ETag or the Last-Modified on reloads.
+// The relative includes are resolved from the URL.
+func NewURLConfigSource(rawURL string) (ConfigSource, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s is not an http(s) URL", rawURL)
+	}
+	return &urlConfigSource{
+		u: u,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		cache: &configCache{
+			entries: make(map[string]*cachedConfig),
+		},
+	}, nil
+}
+
+func (s *urlConfigSource) Read(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "qsgpm")
+	cached := s.cache.get(s.u.String())
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		log.Printf("[debug] %s is not modified", s)
+		return cached.body, nil
+	case resp.StatusCode/100 != 2:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	entry := &cachedConfig{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		body:         bs,
+	}
+	if entry.etag != "" || entry.lastModified != "" {
+		s.cache.set(s.u.String(), entry)
+	}
+	return bs, nil
+}
+
+func (s *urlConfigSource) Include(path string) (ConfigSource, error) {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	u := s.u.ResolveReference(ref)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%s cannot be included from %s", path, s)
+	}
+	return &urlConfigSource{
+		u:      u,
+		client: s.client,
+		cache:  s.cache,
+	}, nil
+}
+
+// String returns the URL without the password.
+func (s *urlConfigSource) String() string {
+	return s.u.Redacted()
+}
+
+// configSourceKey identifies the source to detect circular includes.
+func configSourceKey(src ConfigSource) (string, error) {
+	if s, ok := src.(*fileConfigSource); ok {
+		return filepath.Abs(s.path)
+	}
+	return src.String(), nil
+}
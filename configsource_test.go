@@ -0,0 +1,99 @@
+package qsgpm_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+// newConfigServer serves the files in dir with the ETag, and counts the responses by the status.
+func newConfigServer(t *testing.T, dir string) (*httptest.Server, func() map[int]int) {
+	t.Helper()
+	var mu sync.Mutex
+	statuses := make(map[int]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		defer func() {
+			mu.Lock()
+			defer mu.Unlock()
+			statuses[status]++
+		}()
+		bs, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(r.URL.Path)))
+		if err != nil {
+			status = http.StatusNotFound
+			http.NotFound(w, r)
+			return
+		}
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256(bs))
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			status = http.StatusNotModified
+			w.WriteHeader(status)
+			return
+		}
+		w.Write(bs)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() map[int]int {
+		mu.Lock()
+		defer mu.Unlock()
+		counted := statuses
+		statuses = make(map[int]int)
+		return counted
+	}
+}
+
+func TestConfigLoadURL(t *testing.T) {
+	server, _ := newConfigServer(t, "testdata/layers")
+	cfg := qsgpm.NewDefaultConfig()
+	require.NoError(t, cfg.Load(server.URL+"/staging.yaml"))
+	require.Len(t, cfg.Rules, 3, "base.yaml is included relative to the URL")
+
+	local := qsgpm.NewDefaultConfig()
+	require.NoError(t, local.Load("testdata/layers/staging.yaml"))
+	require.Equal(t, local.Hash(), cfg.Hash())
+
+	err := cfg.Load(server.URL + "/missing.yaml")
+	require.ErrorContains(t, err, "/missing.yaml read failed: unexpected status 404 Not Found")
+}
+
+func TestConfigSourceETag(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"base.yaml", "staging.yaml"} {
+		bs, err := os.ReadFile(filepath.Join("testdata/layers", name))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), bs, 0644))
+	}
+	server, statuses := newConfigServer(t, dir)
+	src, err := qsgpm.NewConfigSource(server.URL + "/staging.yaml")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	first := qsgpm.NewDefaultConfig()
+	require.NoError(t, first.LoadSources(ctx, src))
+	require.Equal(t, map[int]int{http.StatusOK: 2}, statuses())
+
+	second := qsgpm.NewDefaultConfig()
+	require.NoError(t, second.LoadSources(ctx, src))
+	require.Equal(t, map[int]int{http.StatusNotModified: 2}, statuses(), "the cache is revalidated")
+	require.Equal(t, first.Hash(), second.Hash())
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "base.yaml"), []byte("groups: [everyone]\n"), 0644))
+	third := qsgpm.NewDefaultConfig()
+	require.NoError(t, third.LoadSources(ctx, src))
+	require.Equal(t, map[int]int{http.StatusOK: 1, http.StatusNotModified: 1}, statuses())
+	require.NotEqual(t, first.Hash(), third.Hash())
+	require.Equal(t, []string{"everyone"}, third.Groups)
+
+	_, err = src.Include("file:///etc/qsgpm.yaml")
+	require.EqualError(t, err, "file:///etc/qsgpm.yaml cannot be included from "+server.URL+"/staging.yaml")
+}
@@ -0,0 +1,102 @@
+package qsgpm
+
+import (
+	"fmt"
+)
+
+// DriftType is the type of a difference between the current state and the config.
+type DriftType string
+
+const (
+	DriftUnexpectedMembership DriftType = "unexpected_membership"
+	DriftMissingMembership    DriftType = "missing_membership"
+	DriftUnexpectedGroup      DriftType = "unexpected_group"
+	DriftMissingGroup         DriftType = "missing_group"
+	DriftCustomPermission     DriftType = "custom_permission"
+)
+
+// Drift is a difference between the current state and the config, such as a change made outside qsgpm.
+type Drift struct {
+	Type      DriftType `json:"type"`
+	Target    string    `json:"target,omitempty"`
+	Namespace string    `json:"namespace"`
+	GroupName string    `json:"group_name,omitempty"`
+	UserName  string    `json:"user_name,omitempty"`
+	// Actual and Expected are the custom permission names. nil means no custom permission.
+	Actual   *string `json:"actual,omitempty"`
+	Expected *string `json:"expected,omitempty"`
+	Rule     string  `json:"rule,omitempty"`
+	Message  string  `json:"message"`
+}
+
+func (d *Drift) String() string {
+	if d.Target != "" {
+		return fmt.Sprintf("[%s/%s] %s", d.Target, d.Namespace, d.Message)
+	}
+	return fmt.Sprintf("[%s] %s", d.Namespace, d.Message)
+}
+
+// DriftReport is the list of drifts.
+type DriftReport struct {
+	Drifts []*Drift `json:"drifts"`
+}
+
+// HasDrift reports whether the current state differs from the config.
+func (r *DriftReport) HasDrift() bool {
+	return len(r.Drifts) > 0
+}
+
+// NewDriftReport describes the planned changes as the drifts of the current state.
+func NewDriftReport(plan *Plan) *DriftReport {
+	report := &DriftReport{
+		Drifts: make([]*Drift, 0, len(plan.Changes)),
+	}
+	for _, c := range plan.Changes {
+		d := &Drift{
+			Target:    c.Target,
+			Namespace: c.Namespace,
+			GroupName: c.GroupName,
+			UserName:  c.UserName,
+			Rule:      c.Rule,
+		}
+		switch c.Type {
+		case ChangeRemoveMembership:
+			d.Type = DriftUnexpectedMembership
+			switch {
+			case c.Reason != "":
+				d.Message = fmt.Sprintf("%s is in %s, but removed by %s: %s", c.UserName, c.GroupName, c.Rule, c.Reason)
+			case c.Rule != "":
+				d.Message = fmt.Sprintf("%s is in %s, but removed by %s", c.UserName, c.GroupName, c.Rule)
+			default:
+				d.Message = fmt.Sprintf("%s is in %s, but no rule assigns the user", c.UserName, c.GroupName)
+			}
+		case ChangeAddMembership:
+			d.Type = DriftMissingMembership
+			if c.Rule != "" {
+				d.Message = fmt.Sprintf("%s is not in %s, expected by rule %s", c.UserName, c.GroupName, c.Rule)
+			} else {
+				d.Message = fmt.Sprintf("%s is not in %s", c.UserName, c.GroupName)
+			}
+		case ChangeDeleteGroup:
+			d.Type = DriftUnexpectedGroup
+			d.Message = fmt.Sprintf("group %s exists, but no rule assigns it", c.GroupName)
+		case ChangeCreateGroup:
+			d.Type = DriftMissingGroup
+			d.Message = fmt.Sprintf("group %s does not exist", c.GroupName)
+		case ChangeUpdateCustomPermission:
+			d.Type = DriftCustomPermission
+			d.Actual = c.Before
+			d.Expected = c.After
+			if c.Rule != "" {
+				d.Message = fmt.Sprintf("custom permission of %s is %s, expected %s by rule %s", c.UserName, viewStarString(c.Before), viewStarString(c.After), c.Rule)
+			} else {
+				d.Message = fmt.Sprintf("custom permission of %s is %s, expected %s", c.UserName, viewStarString(c.Before), viewStarString(c.After))
+			}
+		default:
+			d.Type = DriftType(c.Type)
+			d.Message = c.String()
+		}
+		report.Drifts = append(report.Drifts, d)
+	}
+	return report
+}
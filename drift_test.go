@@ -0,0 +1,80 @@
+package qsgpm_test
+
+import (
+	"testing"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDriftReport(t *testing.T) {
+	snapshot, err := qsgpm.LoadSnapshot("testdata/snapshots/snapshot.json")
+	require.NoError(t, err)
+	cfg := qsgpm.NewDefaultConfig()
+	err = cfg.Load("testdata/config.yaml")
+	require.NoError(t, err)
+	plan, err := cfg.PlanSnapshot(snapshot)
+	require.NoError(t, err)
+
+	report := qsgpm.NewDriftReport(plan)
+	require.True(t, report.HasDrift())
+	actual := make([]string, 0, len(report.Drifts))
+	for _, d := range report.Drifts {
+		actual = append(actual, d.String())
+	}
+	require.Equal(t, []string{
+		"[default] custom permission of Analyst/piyo@example.com is <nil>, expected analysis by rule rules[2]",
+		"[default] custom permission of Manager/hoge@example.com is analysis, expected manager by rule rules[1]",
+		"[default] group analysts does not exist",
+		"[default] group managers does not exist",
+		"[default] Analyst/piyo@example.com is not in all, expected by rule rules[2]",
+		"[default] Manager/hoge@example.com is not in all, expected by rule rules[1]",
+		"[default] Analyst/piyo@example.com is not in analysts, expected by rule rules[2]",
+		"[default] Analyst/piyo@example.com is not in authors, expected by rule rules[2]",
+		"[default] Manager/hoge@example.com is not in managers, expected by rule rules[1]",
+		"[default] tora is in all, but no rule assigns the user",
+		"[default] tora is in legacy, but no rule assigns the user",
+		"[default] group legacy exists, but no rule assigns it",
+	}, actual)
+
+	require.False(t, qsgpm.NewDriftReport(&qsgpm.Plan{}).HasDrift())
+}
+
+func TestDriftSnapshotCreateOnly(t *testing.T) {
+	snapshot, err := qsgpm.LoadSnapshot("testdata/snapshots/snapshot.json")
+	require.NoError(t, err)
+	cfg := qsgpm.NewDefaultConfig()
+	require.NoError(t, cfg.Load("testdata/config.yaml"))
+	cfg.CreateOnly = true
+
+	plan, err := cfg.PlanSnapshot(snapshot)
+	require.NoError(t, err)
+	require.Len(t, plan.Changes, 9, "create_only plans no removals")
+
+	report, err := cfg.DriftSnapshot(snapshot)
+	require.NoError(t, err)
+	actual := make([]string, 0, len(report.Drifts))
+	for _, d := range report.Drifts {
+		actual = append(actual, d.String())
+	}
+	require.Len(t, actual, 12)
+	require.Contains(t, actual, "[default] tora is in legacy, but no rule assigns the user")
+	require.Contains(t, actual, "[default] group legacy exists, but no rule assigns it")
+}
+
+func TestNewDriftReportMessages(t *testing.T) {
+	report := qsgpm.NewDriftReport(&qsgpm.Plan{
+		Changes: []*qsgpm.Change{
+			{Type: qsgpm.ChangeAddMembership, Namespace: "default", GroupName: "all", UserName: "tora"},
+			{Type: qsgpm.ChangeRemoveMembership, Namespace: "default", GroupName: "authors", UserName: "hoge", Rule: "overrides[hoge]", Reason: "manager only"},
+		},
+	})
+	actual := make([]string, 0, len(report.Drifts))
+	for _, d := range report.Drifts {
+		actual = append(actual, d.String())
+	}
+	require.Equal(t, []string{
+		"[default] tora is not in all",
+		"[default] hoge is in authors, but removed by overrides[hoge]: manager only",
+	}, actual)
+}
@@ -0,0 +1,76 @@
+package qsgpm
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ChangeEvent is emitted for each change applied to QuickSight.
+type ChangeEvent struct {
+	Time      time.Time `json:"time"`
+	AccountID string    `json:"account_id"`
+	*Change
+	RequestID string        `json:"request_id,omitempty"`
+	Duration  time.Duration `json:"-"`
+	DryRun    bool          `json:"dry_run,omitempty"`
+	// Error is the error of the API call, nil if succeeded.
+	Error error `json:"-"`
+}
+
+// ChangeEventHandler handles the change events.
+type ChangeEventHandler func(*ChangeEvent)
+
+func (e *ChangeEvent) MarshalJSON() ([]byte, error) {
+	type event ChangeEvent
+	var errMessage string
+	if e.Error != nil {
+		errMessage = e.Error.Error()
+	}
+	return json.Marshal(struct {
+		*event
+		DurationMS float64 `json:"duration_ms"`
+		Error      string  `json:"error,omitempty"`
+	}{
+		event:      (*event)(e),
+		DurationMS: float64(e.Duration) / float64(time.Millisecond),
+		Error:      errMessage,
+	})
+}
+
+// Message describes the change in the log message.
+func (e *ChangeEvent) Message() string {
+	c := e.Change
+	var msg string
+	switch c.Type {
+	case ChangeUpdateCustomPermission:
+		msg = fmt.Sprintf("update user %s custom permission: %s => %s", c.UserName, viewStarString(c.Before), viewStarString(c.After))
+		if c.Rule != "" {
+			msg += fmt.Sprintf(" by rule %s", c.Rule)
+		}
+	case ChangeCreateGroup:
+		msg = fmt.Sprintf("create group %s", c.GroupName)
+	case ChangeAddMembership:
+		msg = fmt.Sprintf("create group membership %s", Membership{GroupName: c.GroupName, UserName: c.UserName, Rule: c.Rule})
+	case ChangeRemoveMembership:
+		msg = fmt.Sprintf("delete group membership %s", Membership{GroupName: c.GroupName, UserName: c.UserName, Rule: c.Rule})
+	case ChangeDeleteGroup:
+		msg = fmt.Sprintf("delete group %s", c.GroupName)
+	default:
+		msg = string(c.Type)
+	}
+	if e.Error != nil {
+		return fmt.Sprintf("failed to %s: %s", msg, e.Error)
+	}
+	return msg
+}
+
+// LogChangeEvent logs the change event in the text format, which is the default log of the applied changes.
+func LogChangeEvent(e *ChangeEvent) {
+	if e.Error != nil {
+		log.Printf("[error] %s", e.Message())
+		return
+	}
+	log.Printf("[info] %s", e.Message())
+}
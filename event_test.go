@@ -0,0 +1,107 @@
+package qsgpm_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestApp(t *testing.T, configPath string, snapshotPath string, optFns ...func(*qsgpm.AppOptions) error) (*qsgpm.App, *fakeQuickSight) {
+	t.Helper()
+	cfg := qsgpm.NewDefaultConfig()
+	require.NoError(t, cfg.Load(configPath))
+	snapshot, err := qsgpm.LoadSnapshot(snapshotPath)
+	require.NoError(t, err)
+	fake := newFakeQuickSight(snapshot)
+	optFns = append([]func(*qsgpm.AppOptions) error{qsgpm.WithQuickSightClient(fake, "123456789012")}, optFns...)
+	app, err := qsgpm.New(context.Background(), cfg, optFns...)
+	require.NoError(t, err)
+	return app, fake
+}
+
+func TestAppRunChangeEvents(t *testing.T) {
+	events := make([]*qsgpm.ChangeEvent, 0)
+	logged := 0
+	app, fake := newTestApp(t, "testdata/config.yaml", "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {
+			logged++
+		}),
+		qsgpm.WithChangeEventHandler(func(e *qsgpm.ChangeEvent) {
+			events = append(events, e)
+		}),
+	)
+	ctx := context.Background()
+
+	require.NoError(t, app.Run(ctx, qsgpm.RunOption{DryRun: true}))
+	require.Len(t, events, 12)
+	require.Equal(t, 12, logged)
+	for _, e := range events {
+		require.True(t, e.DryRun)
+		require.Equal(t, "<known after run>", e.RequestID)
+	}
+	require.Empty(t, fake.calls, "dry run never calls mutating APIs")
+
+	events = events[:0]
+	require.NoError(t, app.Run(ctx, qsgpm.RunOption{}))
+	messages := make([]string, 0, len(events))
+	for i, e := range events {
+		require.False(t, e.DryRun)
+		require.NoError(t, e.Error)
+		require.Equal(t, "123456789012", e.AccountID)
+		require.Equal(t, fmt.Sprintf("request-%d", i+1), e.RequestID)
+		messages = append(messages, e.Message())
+	}
+	require.Equal(t, []string{
+		"update user Analyst/piyo@example.com custom permission: <nil> => analysis by rule rules[2]",
+		"update user Manager/hoge@example.com custom permission: analysis => manager by rule rules[1]",
+		"create group analysts",
+		"create group managers",
+		"create group membership Analyst/piyo@example.com in all by rule rules[2]",
+		"create group membership Manager/hoge@example.com in all by rule rules[1]",
+		"create group membership Analyst/piyo@example.com in analysts by rule rules[2]",
+		"create group membership Analyst/piyo@example.com in authors by rule rules[2]",
+		"create group membership Manager/hoge@example.com in managers by rule rules[1]",
+		"delete group membership tora in all",
+		"delete group membership tora in legacy",
+		"delete group legacy",
+	}, messages)
+
+	bs, err := json.Marshal(events[2])
+	require.NoError(t, err)
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(bs, &record))
+	require.Equal(t, "create_group", record["type"])
+	require.Equal(t, "analysts", record["group_name"])
+	require.Equal(t, "default", record["namespace"])
+	require.Equal(t, "request-3", record["request_id"])
+	require.Contains(t, record, "duration_ms")
+	require.NotContains(t, record, "error")
+
+	plan, err := app.Plan(ctx)
+	require.NoError(t, err)
+	require.True(t, plan.IsEmpty(), "in sync after run")
+}
+
+func TestAppRunChangeEventsFailed(t *testing.T) {
+	events := make([]*qsgpm.ChangeEvent, 0)
+	app, fake := newTestApp(t, "testdata/config.yaml", "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+		qsgpm.WithChangeEventHandler(func(e *qsgpm.ChangeEvent) {
+			events = append(events, e)
+		}),
+	)
+	fake.failOn = "CreateGroup"
+	err := app.Run(context.Background(), qsgpm.RunOption{})
+	require.EqualError(t, err, "CreateGroup failed")
+	require.Len(t, events, 3)
+	last := events[len(events)-1]
+	require.EqualError(t, last.Error, "CreateGroup failed")
+	require.Equal(t, "failed to create group analysts: CreateGroup failed", last.Message())
+	bs, err := json.Marshal(last)
+	require.NoError(t, err)
+	require.Contains(t, string(bs), `"error":"CreateGroup failed"`)
+}
@@ -0,0 +1,180 @@
+package qsgpm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// parseExpiresAt parses expires_at, an RFC 3339 time or a date which means 00:00 UTC of the day.
+func parseExpiresAt(str string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, str); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", str)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expires_at %s is not an RFC 3339 time or a date such as 2006-01-02", str)
+	}
+	return t, nil
+}
+
+// ExceptionConfig grants groups to a user until it expires, in addition to the groups of the rules,
+// e.g. for incident response or contractors.
+type ExceptionConfig struct {
+	// UserName or Email selects the user.
+	UserName string `yaml:"user_name"`
+	Email    string `yaml:"email"`
+	// Namespace limits the exception to the namespace, all namespaces if empty.
+	Namespace string   `yaml:"namespace"`
+	Groups    []string `yaml:"groups"`
+	ExpiresAt string   `yaml:"expires_at"`
+	Reason    string   `yaml:"reason"`
+
+	label     string
+	expiresAt time.Time
+}
+
+func (cfg *ExceptionConfig) Restrict() error {
+	errs := make([]error, 0)
+	if cfg.UserName == "" && cfg.Email == "" {
+		errs = append(errs, errors.New("user_name or email is required"))
+	}
+	if cfg.UserName != "" && cfg.Email != "" {
+		errs = append(errs, errors.New("only one of user_name or email is allowed"))
+	}
+	if len(cfg.Groups) == 0 {
+		errs = append(errs, errors.New("groups are required"))
+	}
+	if cfg.ExpiresAt == "" {
+		errs = append(errs, errors.New("expires_at is required"))
+	} else if t, err := parseExpiresAt(cfg.ExpiresAt); err != nil {
+		errs = append(errs, err)
+	} else {
+		cfg.expiresAt = t
+	}
+	return errors.Join(errs...)
+}
+
+// String returns the position of the exception in the config.
+func (cfg *ExceptionConfig) String() string {
+	return cfg.label
+}
+
+// Match reports whether the exception is for the user.
+func (cfg *ExceptionConfig) Match(user *User) bool {
+	if cfg.Namespace != "" && cfg.Namespace != user.Namespace {
+		return false
+	}
+	if cfg.UserName != "" {
+		return cfg.UserName == aws.ToString(user.UserName)
+	}
+	return strings.EqualFold(cfg.Email, aws.ToString(user.Email))
+}
+
+// activeExceptions returns the exceptions for the user which have not expired.
+func (cfg *Config) activeExceptions(user *User) []*ExceptionConfig {
+	now := time.Now()
+	active := make([]*ExceptionConfig, 0)
+	for _, e := range cfg.Exceptions {
+		if e.Match(user) && now.Before(e.expiresAt) {
+			active = append(active, e)
+		}
+	}
+	return active
+}
+
+// expiredGroups returns the groups granted to the user by the expired rules and exceptions, with their ancestors.
+// The memberships of them are removed even with create_only, since the grants are meant to be revoked.
+func (cfg *Config) expiredGroups(user *User) map[string]bool {
+	now := time.Now()
+	granted := make([]string, 0)
+	for _, rule := range cfg.getRules(user.Namespace) {
+		if !rule.expired(now) || !rule.User.Match(user) {
+			continue
+		}
+		if groups, ok := rule.GetGroupNames(user); ok {
+			granted = append(granted, groups...)
+		}
+	}
+	for _, e := range cfg.Exceptions {
+		if e.Match(user) && !now.Before(e.expiresAt) {
+			granted = append(granted, e.Groups...)
+		}
+	}
+	expired := make(map[string]bool, len(granted))
+	for _, g := range cfg.hierarchy.expandNames(granted) {
+		expired[g] = true
+	}
+	return expired
+}
+
+// Expiration is a membership which will be removed when the rule or the exception granting it expires.
+type Expiration struct {
+	Target    string    `json:"target,omitempty"`
+	Namespace string    `json:"namespace"`
+	UserName  string    `json:"user_name"`
+	GroupName string    `json:"group_name"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Source is the rule or the exception, with the reason of the exception if any.
+	Source string `json:"source"`
+}
+
+func (e *Expiration) String() string {
+	str := fmt.Sprintf("%s in %s expires at %s (%s)", e.UserName, e.GroupName, e.ExpiresAt.Format(time.RFC3339), e.Source)
+	if e.Target != "" {
+		return fmt.Sprintf("[%s/%s] %s", e.Target, e.Namespace, str)
+	}
+	return fmt.Sprintf("[%s] %s", e.Namespace, str)
+}
+
+// planExpirations returns the memberships of the users granted by the rules or the exceptions which will expire,
+// in the order of the expiration.
+func (cfg *Config) planExpirations(namespace string, users []*User) []*Expiration {
+	expirations := make([]*Expiration, 0)
+	for _, user := range users {
+		if cfg.ignore.matchUser(user) {
+			continue
+		}
+		userName := aws.ToString(user.UserName)
+		if rule, ok := cfg.MatchGroupRule(user); ok && !rule.expiresAt.IsZero() {
+			for _, g := range rule.Groups {
+				expirations = append(expirations, &Expiration{
+					Namespace: namespace,
+					UserName:  userName,
+					GroupName: g,
+					ExpiresAt: rule.expiresAt,
+					Source:    rule.String(),
+				})
+			}
+		}
+		for _, e := range cfg.activeExceptions(user) {
+			source := e.String()
+			if e.Reason != "" {
+				source = fmt.Sprintf("%s: %s", e, e.Reason)
+			}
+			for _, g := range e.Groups {
+				expirations = append(expirations, &Expiration{
+					Namespace: namespace,
+					UserName:  userName,
+					GroupName: g,
+					ExpiresAt: e.expiresAt,
+					Source:    source,
+				})
+			}
+		}
+	}
+	sort.SliceStable(expirations, func(i, j int) bool {
+		if !expirations[i].ExpiresAt.Equal(expirations[j].ExpiresAt) {
+			return expirations[i].ExpiresAt.Before(expirations[j].ExpiresAt)
+		}
+		if expirations[i].UserName != expirations[j].UserName {
+			return expirations[i].UserName < expirations[j].UserName
+		}
+		return expirations[i].GroupName < expirations[j].GroupName
+	})
+	return expirations
+}
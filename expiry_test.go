@@ -0,0 +1,107 @@
+package qsgpm_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigPlanSnapshotExpiration(t *testing.T) {
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	future := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(`required_version: ">=0.0.0"
+user:
+  identity_type: IAM
+  session_name_suffix: "@example.com"
+  namespace: default
+rules:
+  - user:
+      iam_role_name: Developer
+    groups:
+      - admins
+    expires_at: %[1]q
+  - user:
+      iam_role_name: Manager
+    groups:
+      - authors
+    expires_at: %[2]q
+exceptions:
+  - email: PIYO@example.com
+    groups:
+      - authors
+    expires_at: %[2]q
+    reason: incident response
+  - user_name: tora
+    groups:
+      - all
+    expires_at: %[1]q
+`, past, future.Format(time.RFC3339))), 0644))
+	cfg := qsgpm.NewDefaultConfig()
+	require.NoError(t, cfg.Load(configPath))
+	snapshot, err := qsgpm.LoadSnapshot("testdata/snapshots/snapshot.json")
+	require.NoError(t, err)
+	plan, err := cfg.PlanSnapshot(snapshot)
+	require.NoError(t, err)
+	actual := make([]string, 0, len(plan.Changes))
+	for _, change := range plan.Changes {
+		actual = append(actual, change.String())
+	}
+	require.Equal(t, []string{
+		"[default] ~ update custom permission of Manager/hoge@example.com: analysis => <nil>",
+		"[default] + add Analyst/piyo@example.com to authors (rule exceptions[0])",
+		"[default] - remove Developer/admin@example.com from admins",
+		"[default] - remove Developer/admin@example.com from all",
+		"[default] - remove tora from all",
+		"[default] - remove tora from legacy",
+		"[default] - delete group admins",
+		"[default] - delete group all",
+		"[default] - delete group legacy",
+	}, actual, "expired rules and exceptions grant no groups")
+
+	expiresAt := future.Format(time.RFC3339)
+	expirations := make([]string, 0, len(plan.Expirations))
+	for _, e := range plan.Expirations {
+		expirations = append(expirations, e.String())
+	}
+	require.Equal(t, []string{
+		"[default] Analyst/piyo@example.com in authors expires at " + expiresAt + " (exceptions[0]: incident response)",
+		"[default] Manager/hoge@example.com in authors expires at " + expiresAt + " (rules[1])",
+	}, expirations)
+}
+
+func TestConfigPlanSnapshotExpirationCreateOnly(t *testing.T) {
+	base, err := filepath.Abs("testdata/config.yaml")
+	require.NoError(t, err)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(`include: %q
+create_only: true
+exceptions:
+  - user_name: tora
+    groups:
+      - legacy
+    expires_at: %q
+    reason: incident response
+`, base, past)), 0644))
+	cfg := qsgpm.NewDefaultConfig()
+	require.NoError(t, cfg.Load(configPath))
+	snapshot, err := qsgpm.LoadSnapshot("testdata/snapshots/snapshot.json")
+	require.NoError(t, err)
+	plan, err := cfg.PlanSnapshot(snapshot)
+	require.NoError(t, err)
+	removals := make([]string, 0)
+	for _, change := range plan.Changes {
+		if change.Type == qsgpm.ChangeRemoveMembership || change.Type == qsgpm.ChangeDeleteGroup {
+			removals = append(removals, change.String())
+		}
+	}
+	require.Equal(t, []string{
+		"[default] - remove tora from legacy",
+	}, removals, "the expired exception is revoked even with create_only, but tora stays in all")
+}
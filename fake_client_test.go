@@ -0,0 +1,202 @@
+package qsgpm_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/quicksight"
+	"github.com/aws/aws-sdk-go-v2/service/quicksight/types"
+	"github.com/mashiike/qsgpm"
+)
+
+// fakeQuickSight is an in-memory QuickSight, initialized by the first target of a snapshot.
+type fakeQuickSight struct {
+	mu       sync.Mutex
+	users    map[string][]types.User
+	groups   map[string]map[string]map[string]bool
+	requests int
+	calls    []string
+	// failOn makes the API call fail, such as "CreateGroup".
+	failOn string
+}
+
+func newFakeQuickSight(snapshot *qsgpm.Snapshot) *fakeQuickSight {
+	f := &fakeQuickSight{
+		users:  make(map[string][]types.User),
+		groups: make(map[string]map[string]map[string]bool),
+	}
+	for _, ns := range snapshot.Targets[0].Namespaces {
+		state := ns.State()
+		for _, u := range state.Users {
+			f.users[ns.Name] = append(f.users[ns.Name], u.User)
+		}
+		f.groups[ns.Name] = make(map[string]map[string]bool)
+		for _, g := range ns.Groups {
+			f.groups[ns.Name][g.Name] = make(map[string]bool)
+			for _, m := range g.Members {
+				f.groups[ns.Name][g.Name][m] = true
+			}
+		}
+	}
+	return f
+}
+
+func (f *fakeQuickSight) call(name string) (*string, error) {
+	f.calls = append(f.calls, name)
+	if f.failOn == name {
+		return nil, fmt.Errorf("%s failed", name)
+	}
+	f.requests++
+	return aws.String(fmt.Sprintf("request-%d", f.requests)), nil
+}
+
+func (f *fakeQuickSight) ListUsers(ctx context.Context, params *quicksight.ListUsersInput, optFns ...func(*quicksight.Options)) (*quicksight.ListUsersOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users := append([]types.User{}, f.users[*params.Namespace]...)
+	return &quicksight.ListUsersOutput{UserList: users}, nil
+}
+
+func (f *fakeQuickSight) DescribeUser(ctx context.Context, params *quicksight.DescribeUserInput, optFns ...func(*quicksight.Options)) (*quicksight.DescribeUserOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, u := range f.users[*params.Namespace] {
+		if *u.UserName == *params.UserName {
+			user := u
+			return &quicksight.DescribeUserOutput{User: &user}, nil
+		}
+	}
+	return nil, &types.ResourceNotFoundException{Message: aws.String(fmt.Sprintf("user %s not found", *params.UserName))}
+}
+
+func (f *fakeQuickSight) ListUserGroups(ctx context.Context, params *quicksight.ListUserGroupsInput, optFns ...func(*quicksight.Options)) (*quicksight.ListUserGroupsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, 0)
+	for name, members := range f.groups[*params.Namespace] {
+		if members[*params.UserName] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	output := &quicksight.ListUserGroupsOutput{}
+	for _, name := range names {
+		output.GroupList = append(output.GroupList, types.Group{GroupName: aws.String(name)})
+	}
+	return output, nil
+}
+
+func (f *fakeQuickSight) UpdateUser(ctx context.Context, params *quicksight.UpdateUserInput, optFns ...func(*quicksight.Options)) (*quicksight.UpdateUserOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	requestID, err := f.call("UpdateUser")
+	if err != nil {
+		return nil, err
+	}
+	users := f.users[*params.Namespace]
+	for i := range users {
+		if *users[i].UserName != *params.UserName {
+			continue
+		}
+		if params.UnapplyCustomPermissions {
+			users[i].CustomPermissionsName = nil
+		} else {
+			users[i].CustomPermissionsName = params.CustomPermissionsName
+		}
+		return &quicksight.UpdateUserOutput{RequestId: requestID, User: &users[i]}, nil
+	}
+	return nil, fmt.Errorf("user %s not found", *params.UserName)
+}
+
+func (f *fakeQuickSight) ListGroups(ctx context.Context, params *quicksight.ListGroupsInput, optFns ...func(*quicksight.Options)) (*quicksight.ListGroupsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, 0)
+	for name := range f.groups[*params.Namespace] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	output := &quicksight.ListGroupsOutput{}
+	for _, name := range names {
+		output.GroupList = append(output.GroupList, types.Group{GroupName: aws.String(name)})
+	}
+	return output, nil
+}
+
+func (f *fakeQuickSight) DescribeGroup(ctx context.Context, params *quicksight.DescribeGroupInput, optFns ...func(*quicksight.Options)) (*quicksight.DescribeGroupOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.groups[*params.Namespace][*params.GroupName]; !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String(fmt.Sprintf("group %s not found", *params.GroupName))}
+	}
+	return &quicksight.DescribeGroupOutput{Group: &types.Group{GroupName: params.GroupName}}, nil
+}
+
+func (f *fakeQuickSight) CreateGroup(ctx context.Context, params *quicksight.CreateGroupInput, optFns ...func(*quicksight.Options)) (*quicksight.CreateGroupOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	requestID, err := f.call("CreateGroup")
+	if err != nil {
+		return nil, err
+	}
+	if f.groups[*params.Namespace] == nil {
+		f.groups[*params.Namespace] = make(map[string]map[string]bool)
+	}
+	f.groups[*params.Namespace][*params.GroupName] = make(map[string]bool)
+	return &quicksight.CreateGroupOutput{RequestId: requestID}, nil
+}
+
+func (f *fakeQuickSight) DeleteGroup(ctx context.Context, params *quicksight.DeleteGroupInput, optFns ...func(*quicksight.Options)) (*quicksight.DeleteGroupOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	requestID, err := f.call("DeleteGroup")
+	if err != nil {
+		return nil, err
+	}
+	delete(f.groups[*params.Namespace], *params.GroupName)
+	return &quicksight.DeleteGroupOutput{RequestId: requestID}, nil
+}
+
+func (f *fakeQuickSight) ListGroupMemberships(ctx context.Context, params *quicksight.ListGroupMembershipsInput, optFns ...func(*quicksight.Options)) (*quicksight.ListGroupMembershipsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, 0)
+	for name := range f.groups[*params.Namespace][*params.GroupName] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	output := &quicksight.ListGroupMembershipsOutput{}
+	for _, name := range names {
+		output.GroupMemberList = append(output.GroupMemberList, types.GroupMember{MemberName: aws.String(name)})
+	}
+	return output, nil
+}
+
+func (f *fakeQuickSight) CreateGroupMembership(ctx context.Context, params *quicksight.CreateGroupMembershipInput, optFns ...func(*quicksight.Options)) (*quicksight.CreateGroupMembershipOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	requestID, err := f.call("CreateGroupMembership")
+	if err != nil {
+		return nil, err
+	}
+	group, ok := f.groups[*params.Namespace][*params.GroupName]
+	if !ok {
+		return nil, fmt.Errorf("group %s not found", *params.GroupName)
+	}
+	group[*params.MemberName] = true
+	return &quicksight.CreateGroupMembershipOutput{RequestId: requestID}, nil
+}
+
+func (f *fakeQuickSight) DeleteGroupMembership(ctx context.Context, params *quicksight.DeleteGroupMembershipInput, optFns ...func(*quicksight.Options)) (*quicksight.DeleteGroupMembershipOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	requestID, err := f.call("DeleteGroupMembership")
+	if err != nil {
+		return nil, err
+	}
+	delete(f.groups[*params.Namespace][*params.GroupName], *params.MemberName)
+	return &quicksight.DeleteGroupMembershipOutput{RequestId: requestID}, nil
+}
@@ -1,5 +1,7 @@
 package qsgpm
 
+import "fmt"
+
 type Groups map[string]Group
 
 func newGroups() Groups {
@@ -7,8 +9,9 @@ func newGroups() Groups {
 }
 
 type Group struct {
-	name       string
-	membership map[string]struct{}
+	name string
+	// membership is a map of the user name to the rule which assigned the user.
+	membership map[string]string
 }
 
 func (groups Groups) AddGroup(group string) Group {
@@ -16,7 +19,7 @@ func (groups Groups) AddGroup(group string) Group {
 	if !ok {
 		g = Group{
 			name:       group,
-			membership: make(map[string]struct{}),
+			membership: make(map[string]string),
 		}
 		groups[group] = g
 	}
@@ -33,6 +36,44 @@ func (groups Groups) Assign(user string, groupNames []string) {
 	}
 }
 
+// AssignRule assigns the user to the groups of the rule, annotated with the rule.
+func (groups Groups) AssignRule(user string, rule *RuleConfig) {
+	for _, g := range rule.Groups {
+		groups.AddGroup(g).membership[user] = rule.String()
+	}
+}
+
+// assignException assigns the user to the groups of the exception, annotated with the exception
+// unless the user is already assigned to the group.
+func (groups Groups) assignException(user string, e *ExceptionConfig) {
+	for _, g := range e.Groups {
+		group := groups.AddGroup(g)
+		if _, ok := group.membership[user]; !ok {
+			group.membership[user] = e.String()
+		}
+	}
+}
+
+// assignOverride assigns the user to the added groups of the override, annotated with the override
+// unless the user is already assigned to the group.
+func (groups Groups) assignOverride(user string, o *OverrideConfig) {
+	for _, g := range o.AddGroups {
+		group := groups.AddGroup(g)
+		if _, ok := group.membership[user]; !ok {
+			group.membership[user] = o.String()
+		}
+	}
+}
+
+// unassignOverride unassigns the user from the removed groups of the override.
+func (groups Groups) unassignOverride(user string, o *OverrideConfig) {
+	for _, g := range o.RemoveGroups {
+		if group, ok := groups[g]; ok {
+			delete(group.membership, user)
+		}
+	}
+}
+
 func (groups Groups) DiffGroup(other Groups) (add, stable, delete []string) {
 	add = make([]string, 0)
 	delete = make([]string, 0)
@@ -55,6 +96,15 @@ func (groups Groups) DiffGroup(other Groups) (add, stable, delete []string) {
 type Membership struct {
 	GroupName string
 	UserName  string
+	// Rule is the rule which assigned the user to the group, if known.
+	Rule string
+}
+
+func (m Membership) String() string {
+	if m.Rule == "" {
+		return fmt.Sprintf("%s in %s", m.UserName, m.GroupName)
+	}
+	return fmt.Sprintf("%s in %s by rule %s", m.UserName, m.GroupName, m.Rule)
 }
 
 func (groups Groups) DiffMembership(other Groups) (add, stable, delete []Membership) {
@@ -78,15 +128,18 @@ func (groups Groups) DiffMembership(other Groups) (add, stable, delete []Members
 }
 
 func (group Group) Add(user string) {
-	group.membership[user] = struct{}{}
+	if _, ok := group.membership[user]; !ok {
+		group.membership[user] = ""
+	}
 }
 
 func (group Group) Membership() []Membership {
 	membership := make([]Membership, 0, len(group.membership))
-	for user := range group.membership {
+	for user, rule := range group.membership {
 		membership = append(membership, Membership{
 			GroupName: group.name,
 			UserName:  user,
+			Rule:      rule,
 		})
 	}
 	return membership
@@ -99,24 +152,27 @@ func (group Group) Diff(other Group) (add, stable, delete []Membership) {
 	add = make([]Membership, 0)
 	delete = make([]Membership, 0)
 	stable = make([]Membership, 0)
-	for o := range other.membership {
+	for o, rule := range other.membership {
 		if _, ok := group.membership[o]; ok {
 			stable = append(stable, Membership{
 				GroupName: group.name,
 				UserName:  o,
+				Rule:      rule,
 			})
 		} else {
 			add = append(add, Membership{
 				GroupName: group.name,
 				UserName:  o,
+				Rule:      rule,
 			})
 		}
 	}
-	for g := range group.membership {
+	for g, rule := range group.membership {
 		if _, ok := other.membership[g]; !ok {
 			delete = append(delete, Membership{
 				GroupName: group.name,
 				UserName:  g,
+				Rule:      rule,
 			})
 		}
 	}
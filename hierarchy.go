@@ -0,0 +1,126 @@
+package qsgpm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// groupHierarchy is the ancestors of the groups in group_hierarchy.
+// A member of a group is also a member of the ancestors of the group.
+type groupHierarchy map[string][]string
+
+// newGroupHierarchy builds the hierarchy from the children of the parent groups, and rejects cycles.
+func newGroupHierarchy(children map[string][]string) (groupHierarchy, error) {
+	parents := make([]string, 0, len(children))
+	for parent := range children {
+		parents = append(parents, parent)
+	}
+	sort.Strings(parents)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	errs := make([]error, 0)
+	var visit func(path []string)
+	visit = func(path []string) {
+		g := path[len(path)-1]
+		switch state[g] {
+		case visiting:
+			for i, p := range path {
+				if p == g {
+					errs = append(errs, fmt.Errorf("cycle %s", strings.Join(path[i:], " -> ")))
+					break
+				}
+			}
+			return
+		case visited:
+			return
+		}
+		state[g] = visiting
+		for _, child := range children[g] {
+			visit(append(path, child))
+		}
+		state[g] = visited
+	}
+	for _, parent := range parents {
+		visit([]string{parent})
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	h := make(groupHierarchy)
+	var ancestors func(g string, seen map[string]bool) []string
+	directParents := make(map[string][]string)
+	for _, parent := range parents {
+		for _, child := range children[parent] {
+			if !containsString(directParents[child], parent) {
+				directParents[child] = append(directParents[child], parent)
+			}
+		}
+	}
+	ancestors = func(g string, seen map[string]bool) []string {
+		result := make([]string, 0)
+		for _, parent := range directParents[g] {
+			if seen[parent] {
+				continue
+			}
+			seen[parent] = true
+			result = append(result, parent)
+			result = append(result, ancestors(parent, seen)...)
+		}
+		return result
+	}
+	for child := range directParents {
+		h[child] = ancestors(child, make(map[string]bool))
+	}
+	return h, nil
+}
+
+// expandNames returns the groups with their ancestors.
+func (h groupHierarchy) expandNames(groups []string) []string {
+	if len(h) == 0 {
+		return groups
+	}
+	expanded := make([]string, 0, len(groups))
+	for _, g := range groups {
+		if !containsString(expanded, g) {
+			expanded = append(expanded, g)
+		}
+	}
+	for _, g := range groups {
+		for _, a := range h[g] {
+			if !containsString(expanded, a) {
+				expanded = append(expanded, a)
+			}
+		}
+	}
+	return expanded
+}
+
+// expand assigns the members of the groups to the ancestors of the groups,
+// annotated with the rule of the membership of the descendant group.
+func (h groupHierarchy) expand(groups Groups) {
+	if len(h) == 0 {
+		return
+	}
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, a := range h[name] {
+			ancestor := groups.AddGroup(a)
+			for user, rule := range groups[name].membership {
+				if _, ok := ancestor.membership[user]; !ok {
+					ancestor.membership[user] = rule
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package qsgpm_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/quicksight/types"
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigPlanSnapshotGroupHierarchy(t *testing.T) {
+	base, err := filepath.Abs("testdata/config.yaml")
+	require.NoError(t, err)
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(`include: %q
+group_hierarchy:
+  staff:
+    - managers
+    - admins
+  managers:
+    - analysts
+`, base)), 0644))
+	cfg := qsgpm.NewDefaultConfig()
+	require.NoError(t, cfg.Load(configPath))
+	snapshot, err := qsgpm.LoadSnapshot("testdata/snapshots/snapshot.json")
+	require.NoError(t, err)
+	plan, err := cfg.PlanSnapshot(snapshot)
+	require.NoError(t, err)
+	actual := make([]string, 0, len(plan.Changes))
+	for _, change := range plan.Changes {
+		actual = append(actual, change.String())
+	}
+	require.Equal(t, []string{
+		"[default] ~ update custom permission of Analyst/piyo@example.com: <nil> => analysis (rule rules[2])",
+		"[default] ~ update custom permission of Manager/hoge@example.com: analysis => manager (rule rules[1])",
+		"[default] + create group analysts",
+		"[default] + create group managers",
+		"[default] + create group staff",
+		"[default] + add Analyst/piyo@example.com to all (rule rules[2])",
+		"[default] + add Manager/hoge@example.com to all (rule rules[1])",
+		"[default] + add Analyst/piyo@example.com to analysts (rule rules[2])",
+		"[default] + add Analyst/piyo@example.com to authors (rule rules[2])",
+		"[default] + add Analyst/piyo@example.com to managers (rule rules[2])",
+		"[default] + add Manager/hoge@example.com to managers (rule rules[1])",
+		"[default] + add Analyst/piyo@example.com to staff (rule rules[2])",
+		"[default] + add Developer/admin@example.com to staff (rule rules[0])",
+		"[default] + add Manager/hoge@example.com to staff (rule rules[1])",
+		"[default] - remove tora from all",
+		"[default] - remove tora from legacy",
+		"[default] - delete group legacy",
+	}, actual, "analysts are members of managers and staff")
+
+	groups, ok := cfg.GetGroupNames(&qsgpm.User{
+		User: types.User{
+			Email:        aws.String("piyo@example.com"),
+			UserName:     aws.String("Analyst/piyo@example.com"),
+			IdentityType: types.IdentityTypeIam,
+			Role:         types.UserRoleAuthor,
+		},
+		Namespace: "default",
+	})
+	require.True(t, ok)
+	require.ElementsMatch(t, []string{"all", "authors", "analysts", "managers", "staff"}, groups)
+}
+
+func TestConfigGroupHierarchyOverrideRemovesParent(t *testing.T) {
+	base, err := filepath.Abs("testdata/config.yaml")
+	require.NoError(t, err)
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(`include: %q
+group_hierarchy:
+  staff:
+    - managers
+  managers:
+    - analysts
+overrides:
+  Analyst/piyo@example.com:
+    remove_groups:
+      - staff
+    reason: not staff yet
+`, base)), 0644))
+	cfg := qsgpm.NewDefaultConfig()
+	require.NoError(t, cfg.Load(configPath))
+	groups, ok := cfg.GetGroupNames(&qsgpm.User{
+		User: types.User{
+			Email:        aws.String("piyo@example.com"),
+			UserName:     aws.String("Analyst/piyo@example.com"),
+			IdentityType: types.IdentityTypeIam,
+			Role:         types.UserRoleAuthor,
+		},
+		Namespace: "default",
+	})
+	require.True(t, ok)
+	require.ElementsMatch(t, []string{"all", "authors", "analysts", "managers"}, groups)
+
+	snapshot, err := qsgpm.LoadSnapshot("testdata/snapshots/snapshot.json")
+	require.NoError(t, err)
+	plan, err := cfg.PlanSnapshot(snapshot)
+	require.NoError(t, err)
+	staff := make([]string, 0)
+	for _, change := range plan.Changes {
+		if change.Type == qsgpm.ChangeAddMembership && change.GroupName == "staff" {
+			staff = append(staff, change.UserName)
+		}
+	}
+	require.Equal(t, []string{"Manager/hoge@example.com"}, staff, "the override removes the parent group")
+}
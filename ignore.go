@@ -0,0 +1,135 @@
+package qsgpm
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// namePattern matches an exact name, or a glob pattern with * and ?.
+// Unlike path.Match, * also matches / since the user names of IAM users contain /.
+type namePattern struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+func newNamePattern(pattern string) (*namePattern, error) {
+	if strings.TrimSpace(pattern) == "" {
+		return nil, errors.New("empty pattern")
+	}
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+	}
+	return &namePattern{pattern: pattern, re: re}, nil
+}
+
+func (p *namePattern) Match(name string) bool {
+	return p.re.MatchString(name)
+}
+
+// ignoreList is the users and the groups never touched by qsgpm.
+type ignoreList struct {
+	users  []*namePattern
+	groups []*namePattern
+}
+
+func newIgnoreList(users, groups []string) (*ignoreList, error) {
+	errs := make([]error, 0)
+	l := &ignoreList{}
+	for i, p := range users {
+		np, err := newNamePattern(p)
+		if err != nil {
+			errs = append(errs, wrapConfigError(fmt.Sprintf("ignore_users[%d]", i), err))
+			continue
+		}
+		l.users = append(l.users, np)
+	}
+	for i, p := range groups {
+		np, err := newNamePattern(p)
+		if err != nil {
+			errs = append(errs, wrapConfigError(fmt.Sprintf("ignore_groups[%d]", i), err))
+			continue
+		}
+		l.groups = append(l.groups, np)
+	}
+	return l, errors.Join(errs...)
+}
+
+// matchUserName reports whether the user name is ignored.
+func (l *ignoreList) matchUserName(name string) bool {
+	if l == nil {
+		return false
+	}
+	for _, p := range l.users {
+		if p.Match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchUser reports whether the user is ignored by the user name or the email.
+func (l *ignoreList) matchUser(user *User) bool {
+	if l.matchUserName(aws.ToString(user.UserName)) {
+		return true
+	}
+	if email := aws.ToString(user.Email); email != "" {
+		return l.matchUserName(email)
+	}
+	return false
+}
+
+// matchGroup reports whether the group is ignored.
+func (l *ignoreList) matchGroup(name string) bool {
+	if l == nil {
+		return false
+	}
+	for _, p := range l.groups {
+		if p.Match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the groups without the ignored groups and the memberships of the ignored users.
+// ignoredUsers are the user names ignored by the email.
+func (l *ignoreList) filter(groups Groups, ignoredUsers map[string]bool) Groups {
+	filtered := newGroups()
+	for name, g := range groups {
+		if l.matchGroup(name) {
+			continue
+		}
+		fg := filtered.AddGroup(name)
+		for user, rule := range g.membership {
+			if ignoredUsers[user] || l.matchUserName(user) {
+				continue
+			}
+			fg.membership[user] = rule
+		}
+	}
+	return filtered
+}
+
+// apply filters the current and the expected groups for diffing.
+// The groups with ignored members are kept, since deleting them removes the memberships of the ignored users.
+func (l *ignoreList) apply(nowGroups, expectGroups Groups, ignoredUsers map[string]bool) (Groups, Groups) {
+	if l == nil && len(ignoredUsers) == 0 {
+		return nowGroups, expectGroups
+	}
+	filteredNow := l.filter(nowGroups, ignoredUsers)
+	filteredExpect := l.filter(expectGroups, ignoredUsers)
+	for name, g := range nowGroups {
+		if _, ok := filteredNow[name]; ok && len(g.membership) > len(filteredNow[name].membership) {
+			filteredExpect.AddGroup(name)
+		}
+	}
+	return filteredNow, filteredExpect
+}
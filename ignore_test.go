@@ -0,0 +1,59 @@
+package qsgpm_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func newIgnoreConfig(t *testing.T) string {
+	t.Helper()
+	base, err := filepath.Abs("testdata/config.yaml")
+	require.NoError(t, err)
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(`include: %q
+ignore_users:
+  - "*@example.net"
+  - Manager/hoge@example.com
+ignore_groups:
+  - author?
+`, base)), 0644))
+	return configPath
+}
+
+func TestConfigPlanSnapshotIgnore(t *testing.T) {
+	cfg := qsgpm.NewDefaultConfig()
+	require.NoError(t, cfg.Load(newIgnoreConfig(t)))
+	snapshot, err := qsgpm.LoadSnapshot("testdata/snapshots/snapshot.json")
+	require.NoError(t, err)
+	plan, err := cfg.PlanSnapshot(snapshot)
+	require.NoError(t, err)
+	actual := make([]string, 0, len(plan.Changes))
+	for _, change := range plan.Changes {
+		actual = append(actual, change.String())
+	}
+	require.Equal(t, []string{
+		"[default] ~ update custom permission of Analyst/piyo@example.com: <nil> => analysis (rule rules[2])",
+		"[default] + create group analysts",
+		"[default] + add Analyst/piyo@example.com to all (rule rules[2])",
+		"[default] + add Analyst/piyo@example.com to analysts (rule rules[2])",
+	}, actual, "legacy is not deleted since tora is ignored by the email")
+}
+
+func TestAppRunIgnore(t *testing.T) {
+	app, fake := newTestApp(t, newIgnoreConfig(t), "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+	)
+	defer app.Close()
+	summary, err := app.RunWithSummary(context.Background(), qsgpm.RunOption{})
+	require.NoError(t, err)
+	require.Len(t, mutatingCalls(fake.calls), 4)
+	require.Equal(t, 2, summary.UsersScanned, "ignored users are not scanned")
+	require.Equal(t, map[string]bool{"tora": true}, fake.groups["default"]["legacy"])
+	require.Equal(t, map[string]bool{"Manager/hoge@example.com": true}, fake.groups["default"]["authors"])
+}
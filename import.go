@@ -0,0 +1,437 @@
+package qsgpm
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"gopkg.in/yaml.v3"
+)
+
+// ImportIssue is a part of the current state which the generated config can not reproduce.
+type ImportIssue struct {
+	Namespace string
+	Message   string
+}
+
+func (issue *ImportIssue) String() string {
+	return fmt.Sprintf("[%s] %s", issue.Namespace, issue.Message)
+}
+
+// GenerateConfig infers rules which reproduce the groups, memberships and custom permissions in the snapshot,
+// and returns the config in YAML with the issues about the state which the rules can not reproduce.
+//
+// Users are classified by their groups and custom permission, and each class becomes a rule
+// with the conditions of role, identity_type, iam_role_name and email_suffix shared by the users of the class,
+// as long as the conditions match no other users. Since the generated rules never overlap, the order does not matter.
+func GenerateConfig(snapshot *Snapshot) ([]byte, []*ImportIssue, error) {
+	states := make(map[string]*NamespaceState)
+	namespaces := make([]string, 0)
+	for _, t := range snapshot.Targets {
+		for _, ns := range t.Namespaces {
+			state := ns.State()
+			if s, ok := states[ns.Name]; ok {
+				s.Users = append(s.Users, state.Users...)
+				for name, group := range state.Groups {
+					for member := range group.membership {
+						s.Groups.Add(name, member)
+					}
+					s.Groups.AddGroup(name)
+				}
+				continue
+			}
+			states[ns.Name] = state
+			namespaces = append(namespaces, ns.Name)
+		}
+	}
+	sort.Strings(namespaces)
+
+	issues := make([]*ImportIssue, 0)
+	ids := make(map[string]bool)
+	rulesByNamespace := make(map[string][]*generatedRule, len(namespaces))
+	for _, namespace := range namespaces {
+		g := &configGenerator{
+			namespace: namespace,
+			ids:       ids,
+		}
+		rulesByNamespace[namespace] = g.generate(states[namespace])
+		issues = append(issues, g.issues...)
+	}
+
+	doc := &generatedConfig{}
+	if len(namespaces) == 1 && namespaces[0] == "default" {
+		doc.User = &generatedUserConfig{Namespace: "default"}
+		doc.Rules = rulesByNamespace["default"]
+	} else {
+		doc.Namespaces = make(map[string]*generatedNamespaceConfig, len(namespaces))
+		for _, namespace := range namespaces {
+			doc.Namespaces[namespace] = &generatedNamespaceConfig{Rules: rulesByNamespace[namespace]}
+		}
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), issues, nil
+}
+
+// generatedConfig is the config generated by GenerateConfig, in the order of the keys.
+type generatedConfig struct {
+	User       *generatedUserConfig                 `yaml:"user,omitempty"`
+	Rules      []*generatedRule                     `yaml:"rules,omitempty"`
+	Namespaces map[string]*generatedNamespaceConfig `yaml:"namespaces,omitempty"`
+}
+
+type generatedNamespaceConfig struct {
+	Rules []*generatedRule `yaml:"rules"`
+}
+
+type generatedUserConfig struct {
+	Namespace    string `yaml:"namespace,omitempty"`
+	IdentityType string `yaml:"identity_type,omitempty"`
+	Role         string `yaml:"role,omitempty"`
+	IAMRoleName  string `yaml:"iam_role_name,omitempty"`
+	EmailSuffix  string `yaml:"email_suffix,omitempty"`
+}
+
+type generatedRule struct {
+	ID               string               `yaml:"id"`
+	Description      string               `yaml:"description"`
+	User             *generatedUserConfig `yaml:"user,omitempty"`
+	Groups           []string             `yaml:"groups,omitempty"`
+	CustomPermission *string              `yaml:"custom_permission,omitempty"`
+}
+
+// userOutcome is the groups and the custom permission of a user.
+type userOutcome struct {
+	groups           []string
+	customPermission *string
+}
+
+func (o userOutcome) key() string {
+	return strings.Join(o.groups, ",") + "|" + viewStarString(o.customPermission)
+}
+
+func (o userOutcome) isEmpty() bool {
+	return len(o.groups) == 0 && o.customPermission == nil
+}
+
+// userFeatures are the attributes of a user which can be conditions of a rule.
+type userFeatures struct {
+	identityType string
+	role         string
+	iamRoleName  string
+	emailSuffix  string
+}
+
+func featuresOf(user *User) userFeatures {
+	f := userFeatures{
+		identityType: string(user.IdentityType),
+		role:         string(user.Role),
+		iamRoleName:  user.IAMRoleName(),
+	}
+	if email := aws.ToString(user.Email); strings.Contains(email, "@") {
+		f.emailSuffix = email[strings.LastIndex(email, "@"):]
+	}
+	return f
+}
+
+// common returns the features shared with the other, and empty for the others.
+func (f userFeatures) common(other userFeatures) userFeatures {
+	if f.identityType != other.identityType {
+		f.identityType = ""
+	}
+	if f.role != other.role {
+		f.role = ""
+	}
+	if f.iamRoleName != other.iamRoleName {
+		f.iamRoleName = ""
+	}
+	if f.emailSuffix != other.emailSuffix {
+		f.emailSuffix = ""
+	}
+	return f
+}
+
+func (f userFeatures) match(other userFeatures) bool {
+	return (f.identityType == "" || f.identityType == other.identityType) &&
+		(f.role == "" || f.role == other.role) &&
+		(f.iamRoleName == "" || f.iamRoleName == other.iamRoleName) &&
+		(f.emailSuffix == "" || f.emailSuffix == other.emailSuffix)
+}
+
+// generalizations returns the features with one of the conditions removed,
+// in the order of the conditions less meaningful for the rule.
+func (f userFeatures) generalizations() []userFeatures {
+	candidates := make([]userFeatures, 0, 4)
+	if f.identityType != "" {
+		g := f
+		g.identityType = ""
+		candidates = append(candidates, g)
+	}
+	if f.emailSuffix != "" {
+		g := f
+		g.emailSuffix = ""
+		candidates = append(candidates, g)
+	}
+	if f.role != "" {
+		g := f
+		g.role = ""
+		candidates = append(candidates, g)
+	}
+	if f.iamRoleName != "" {
+		g := f
+		g.iamRoleName = ""
+		candidates = append(candidates, g)
+	}
+	return candidates
+}
+
+var ruleIDInvalidChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+func (f userFeatures) id() string {
+	parts := make([]string, 0, 4)
+	for _, part := range []string{f.iamRoleName, f.role, f.identityType, strings.TrimPrefix(f.emailSuffix, "@")} {
+		if part == "" {
+			continue
+		}
+		parts = append(parts, strings.Trim(ruleIDInvalidChars.ReplaceAllString(strings.ToLower(part), "-"), "-"))
+	}
+	if len(parts) == 0 {
+		return "all"
+	}
+	return strings.Join(parts, "-")
+}
+
+func (f userFeatures) String() string {
+	parts := make([]string, 0, 4)
+	for _, kv := range [][2]string{
+		{"identity_type", f.identityType},
+		{"role", f.role},
+		{"iam_role_name", f.iamRoleName},
+		{"email_suffix", f.emailSuffix},
+	} {
+		if kv[1] != "" {
+			parts = append(parts, kv[0]+"="+kv[1])
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+type generatedUser struct {
+	name     string
+	features userFeatures
+	outcome  userOutcome
+}
+
+type configGenerator struct {
+	namespace string
+	ids       map[string]bool
+	users     []*generatedUser
+	issues    []*ImportIssue
+}
+
+func (g *configGenerator) reportf(format string, args ...interface{}) {
+	g.issues = append(g.issues, &ImportIssue{
+		Namespace: g.namespace,
+		Message:   fmt.Sprintf(format, args...),
+	})
+}
+
+func (g *configGenerator) generate(state *NamespaceState) []*generatedRule {
+	groupsOfUser := make(map[string][]string)
+	groupNames := make([]string, 0, len(state.Groups))
+	for name := range state.Groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+	userNames := make(map[string]bool, len(state.Users))
+	for _, user := range state.Users {
+		userNames[aws.ToString(user.UserName)] = true
+	}
+	for _, name := range groupNames {
+		members := state.Groups[name].membership
+		if len(members) == 0 {
+			g.reportf("group %s has no members, and will be deleted by the generated config", name)
+		}
+		memberNames := make([]string, 0, len(members))
+		for member := range members {
+			memberNames = append(memberNames, member)
+		}
+		sort.Strings(memberNames)
+		for _, member := range memberNames {
+			if !userNames[member] {
+				g.reportf("%s in %s is not a user of the namespace", member, name)
+				continue
+			}
+			groupsOfUser[member] = append(groupsOfUser[member], name)
+		}
+	}
+
+	classes := make(map[string][]*generatedUser)
+	keys := make([]string, 0)
+	for _, user := range state.Users {
+		u := &generatedUser{
+			name:     aws.ToString(user.UserName),
+			features: featuresOf(user),
+			outcome: userOutcome{
+				groups:           groupsOfUser[aws.ToString(user.UserName)],
+				customPermission: user.CustomPermissionsName,
+			},
+		}
+		g.users = append(g.users, u)
+		if u.outcome.isEmpty() {
+			continue
+		}
+		key := u.outcome.key()
+		if _, ok := classes[key]; !ok {
+			keys = append(keys, key)
+		}
+		classes[key] = append(classes[key], u)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(classes[keys[i]]) != len(classes[keys[j]]) {
+			return len(classes[keys[i]]) > len(classes[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+
+	rules := make([]*generatedRule, 0, len(keys))
+	for _, key := range keys {
+		class := classes[key]
+		if f, ok := g.condition(class); ok {
+			rules = append(rules, g.rule(f, class))
+			continue
+		}
+		// split the class by the all features, since no shared condition explains the class.
+		subclasses := make(map[userFeatures][]*generatedUser)
+		order := make([]userFeatures, 0)
+		for _, u := range class {
+			if _, ok := subclasses[u.features]; !ok {
+				order = append(order, u.features)
+			}
+			subclasses[u.features] = append(subclasses[u.features], u)
+		}
+		for _, features := range order {
+			subclass := subclasses[features]
+			if f, ok := g.condition(subclass); ok {
+				rules = append(rules, g.rule(f, subclass))
+				continue
+			}
+			if g.majority(features, subclass[0].outcome) {
+				// users with the same features can not be distinguished, so the most common outcome wins.
+				f, _ := g.generalize(features, subclass[0].outcome, &features)
+				rules = append(rules, g.rule(f, subclass))
+				continue
+			}
+			for _, u := range subclass {
+				g.reportf("groups [%s] and custom permission %s of %s are not explained, since other users with %s have different ones",
+					strings.Join(u.outcome.groups, ", "), viewStarString(u.outcome.customPermission), u.name, features)
+			}
+		}
+	}
+	return rules
+}
+
+// condition returns the most general condition shared by the users and matching no users with other outcomes.
+func (g *configGenerator) condition(class []*generatedUser) (userFeatures, bool) {
+	f := class[0].features
+	for _, u := range class[1:] {
+		f = f.common(u.features)
+	}
+	return g.generalize(f, class[0].outcome, nil)
+}
+
+// generalize removes the conditions as long as the conditions match no users with other outcomes,
+// except the users with the ignored features.
+func (g *configGenerator) generalize(f userFeatures, outcome userOutcome, ignore *userFeatures) (userFeatures, bool) {
+	if !g.exclusive(f, outcome, ignore) {
+		return f, false
+	}
+	for {
+		generalized := false
+		for _, candidate := range f.generalizations() {
+			if g.exclusive(candidate, outcome, ignore) {
+				f = candidate
+				generalized = true
+				break
+			}
+		}
+		if !generalized {
+			return f, true
+		}
+	}
+}
+
+func (g *configGenerator) exclusive(f userFeatures, outcome userOutcome, ignore *userFeatures) bool {
+	key := outcome.key()
+	for _, u := range g.users {
+		if ignore != nil && u.features == *ignore {
+			continue
+		}
+		if f.match(u.features) && u.outcome.key() != key {
+			return false
+		}
+	}
+	return true
+}
+
+// majority reports whether the outcome is the most common among the users with the features,
+// and the features distinguish the users from all other users with different outcomes.
+func (g *configGenerator) majority(f userFeatures, outcome userOutcome) bool {
+	counts := make(map[string]int)
+	for _, u := range g.users {
+		if !f.match(u.features) {
+			continue
+		}
+		if u.features != f && u.outcome.key() != outcome.key() {
+			return false
+		}
+		counts[u.outcome.key()]++
+	}
+	key := outcome.key()
+	for k, n := range counts {
+		if n > counts[key] || (n == counts[key] && k < key) {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *configGenerator) rule(f userFeatures, class []*generatedUser) *generatedRule {
+	id := f.id()
+	if g.namespace != "default" {
+		id = g.namespace + "-" + id
+	}
+	base := id
+	for i := 2; g.ids[id]; i++ {
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+	g.ids[id] = true
+
+	rule := &generatedRule{
+		ID:          id,
+		Description: fmt.Sprintf("generated from %d user(s)", len(class)),
+	}
+	user := &generatedUserConfig{
+		IdentityType: f.identityType,
+		Role:         f.role,
+		IAMRoleName:  f.iamRoleName,
+		EmailSuffix:  f.emailSuffix,
+	}
+	if *user != (generatedUserConfig{}) {
+		rule.User = user
+	}
+	outcome := class[0].outcome
+	rule.Groups = outcome.groups
+	rule.CustomPermission = outcome.customPermission
+	return rule
+}
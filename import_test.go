@@ -0,0 +1,46 @@
+package qsgpm_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateConfig(t *testing.T) {
+	snapshot, err := qsgpm.LoadSnapshot("testdata/snapshots/import.yaml")
+	require.NoError(t, err)
+	bs, issues, err := qsgpm.GenerateConfig(snapshot)
+	require.NoError(t, err)
+
+	actualIssues := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		actualIssues = append(actualIssues, issue.String())
+	}
+	require.Equal(t, []string{
+		"[default] ghost in all is not a user of the namespace",
+		"[default] group empty has no members, and will be deleted by the generated config",
+		"[default] groups [all, analysts, managers] and custom permission analysis of Analyst/e@example.com are not explained, since other users with identity_type=IAM, role=AUTHOR, iam_role_name=Analyst, email_suffix=@example.com have different ones",
+	}, actualIssues)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, bs, 0644))
+	cfg := qsgpm.NewDefaultConfig()
+	require.NoError(t, cfg.Load(path))
+	require.Empty(t, cfg.Lint(), "generated rules never overlap")
+
+	plan, err := cfg.PlanSnapshot(snapshot)
+	require.NoError(t, err)
+	actualChanges := make([]string, 0, len(plan.Changes))
+	for _, change := range plan.Changes {
+		actualChanges = append(actualChanges, change.String())
+	}
+	require.Equal(t, []string{
+		"[default] - remove ghost from all",
+		"[default] - remove Analyst/e@example.com from managers",
+		"[default] - delete group empty",
+		"[default] - delete group managers",
+	}, actualChanges, "only the unexplained state is changed by the generated config")
+}
@@ -0,0 +1,97 @@
+package quicksightx
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/quicksight"
+)
+
+/*
+ * The original, original code is here; https://github.com/aws/aws-sdk-go-v2/blob/service/quicksight/v1.18.0/service/quicksight/api_op_ListAnalyses.go#L158
+ * The license for the original code is here.; https://github.com/aws/aws-sdk-go-v2/blob/service/quicksight/v1.18.0/LICENSE.txt
+ *
+ * implemented the ListUserGroups one by referring to the ListAnalyses paginator.
+ * This is a temporary solution.
+ */
+
+// ListUserGroupsAPIClient is a client that implements the ListUserGroups operation.
+type ListUserGroupsAPIClient interface {
+	ListUserGroups(context.Context, *quicksight.ListUserGroupsInput, ...func(*quicksight.Options)) (*quicksight.ListUserGroupsOutput, error)
+}
+
+// ListUserGroupsPaginatorOptions is the paginator options for ListUserGroups
+type ListUserGroupsPaginatorOptions struct {
+	// The maximum number of results to return.
+	MaxResults *int32
+
+	// Set to true if pagination should stop if the service returns a pagination token
+	// that matches the most recent token provided to the service.
+	StopOnDuplicateToken bool
+}
+
+// ListUserGroupsPaginator is a paginator for ListUserGroups
+type ListUserGroupsPaginator struct {
+	options   ListUserGroupsPaginatorOptions
+	client    ListUserGroupsAPIClient
+	params    *quicksight.ListUserGroupsInput
+	nextToken *string
+	firstPage bool
+}
+
+// NewListUserGroupsPaginator returns a new ListUserGroupsPaginator
+func NewListUserGroupsPaginator(client ListUserGroupsAPIClient, params *quicksight.ListUserGroupsInput, optFns ...func(*ListUserGroupsPaginatorOptions)) *ListUserGroupsPaginator {
+	if params == nil {
+		params = &quicksight.ListUserGroupsInput{}
+	}
+
+	options := ListUserGroupsPaginatorOptions{}
+	options.MaxResults = params.MaxResults
+
+	for _, fn := range optFns {
+		fn(&options)
+	}
+
+	return &ListUserGroupsPaginator{
+		options:   options,
+		client:    client,
+		params:    params,
+		firstPage: true,
+		nextToken: params.NextToken,
+	}
+}
+
+// HasMorePages returns a boolean indicating whether more pages are available
+func (p *ListUserGroupsPaginator) HasMorePages() bool {
+	return p.firstPage || (p.nextToken != nil && len(*p.nextToken) != 0)
+}
+
+// NextPage retrieves the next ListUserGroups page.
+func (p *ListUserGroupsPaginator) NextPage(ctx context.Context, optFns ...func(*quicksight.Options)) (*quicksight.ListUserGroupsOutput, error) {
+	if !p.HasMorePages() {
+		return nil, fmt.Errorf("no more pages available")
+	}
+
+	params := *p.params
+	params.NextToken = p.nextToken
+
+	params.MaxResults = p.options.MaxResults
+
+	result, err := p.client.ListUserGroups(ctx, &params, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	p.firstPage = false
+
+	prevToken := p.nextToken
+	p.nextToken = result.NextToken
+
+	if p.options.StopOnDuplicateToken &&
+		prevToken != nil &&
+		p.nextToken != nil &&
+		*prevToken == *p.nextToken {
+		p.nextToken = nil
+	}
+
+	return result, nil
+}
@@ -0,0 +1,119 @@
+package qsgpm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// lambdaEvent is the payload of the Lambda function: an EventBridge event, or a custom payload.
+type lambdaEvent struct {
+	DetailType string           `json:"detail-type"`
+	Detail     *cloudTrailEvent `json:"detail"`
+
+	Namespaces []string `json:"namespaces"`
+	Users      []string `json:"users"`
+	DryRun     bool     `json:"dry_run"`
+}
+
+// cloudTrailEvent is the detail of an EventBridge event of a CloudTrail API call.
+type cloudTrailEvent struct {
+	EventSource       string `json:"eventSource"`
+	EventName         string `json:"eventName"`
+	RequestParameters *struct {
+		Namespace   string `json:"namespace"`
+		UserName    string `json:"userName"`
+		IAMArn      string `json:"iamArn"`
+		SessionName string `json:"sessionName"`
+	} `json:"requestParameters"`
+	ResponseElements *struct {
+		User *struct {
+			UserName string `json:"userName"`
+		} `json:"user"`
+	} `json:"responseElements"`
+}
+
+const eventBridgeCloudTrailDetailType = "AWS API Call via CloudTrail"
+
+// ParseLambdaEvent returns the run option for the payload of the Lambda function.
+//
+//   - A CloudTrail RegisterUser or UpdateUser event of QuickSight reconciles the user.
+//   - A custom payload such as {"namespaces": ["default"], "users": ["alice"], "dry_run": true} selects them.
+//   - Other payloads, such as a scheduled event, run the full sweep.
+//
+// ok is false for the CloudTrail events which need no reconciliation.
+func ParseLambdaEvent(payload []byte) (opt RunOption, ok bool, err error) {
+	var e lambdaEvent
+	if len(payload) > 0 && string(payload) != "null" {
+		if err := json.Unmarshal(payload, &e); err != nil {
+			return RunOption{}, false, fmt.Errorf("invalid event: %w", err)
+		}
+	}
+	if e.DetailType != eventBridgeCloudTrailDetailType || e.Detail == nil {
+		return RunOption{
+			Namespaces: e.Namespaces,
+			Users:      e.Users,
+			DryRun:     e.DryRun,
+		}, true, nil
+	}
+	if e.Detail.EventSource != "quicksight.amazonaws.com" {
+		return RunOption{}, false, nil
+	}
+	switch e.Detail.EventName {
+	case "RegisterUser", "UpdateUser":
+	default:
+		return RunOption{}, false, nil
+	}
+	if e.Detail.RequestParameters == nil {
+		return RunOption{}, false, fmt.Errorf("%s event has no requestParameters", e.Detail.EventName)
+	}
+	userName := e.Detail.userName()
+	if userName == "" {
+		return RunOption{}, false, fmt.Errorf("%s event has no user name", e.Detail.EventName)
+	}
+	opt = RunOption{
+		Users: []string{userName},
+	}
+	if namespace := e.Detail.RequestParameters.Namespace; namespace != "" {
+		opt.Namespaces = []string{namespace}
+	}
+	return opt, true, nil
+}
+
+// userName returns the QuickSight user name of the event.
+// The user name of an IAM user registered by RegisterUser is the IAM user name, or the role name and the session name.
+func (e *cloudTrailEvent) userName() string {
+	if e.ResponseElements != nil && e.ResponseElements.User != nil && e.ResponseElements.User.UserName != "" {
+		return e.ResponseElements.User.UserName
+	}
+	params := e.RequestParameters
+	if params.UserName != "" {
+		return params.UserName
+	}
+	name, err := iamArnUserName(params.IAMArn)
+	if err != nil {
+		return ""
+	}
+	if strings.HasPrefix(name, "role/") {
+		if params.SessionName == "" {
+			return ""
+		}
+		return strings.TrimPrefix(name, "role/") + "/" + params.SessionName
+	}
+	return strings.TrimPrefix(name, "user/")
+}
+
+// iamArnUserName returns the resource of the IAM ARN without the path, such as role/Developer or user/alice.
+func iamArnUserName(arn string) (string, error) {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "iam" {
+		return "", errors.New("not an IAM ARN")
+	}
+	resource := strings.SplitN(parts[5], "/", 2)
+	if len(resource) != 2 {
+		return "", errors.New("not an IAM user or role ARN")
+	}
+	path := strings.Split(resource[1], "/")
+	return resource[0] + "/" + path[len(path)-1], nil
+}
@@ -0,0 +1,106 @@
+package qsgpm_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLambdaEvent(t *testing.T) {
+	cases := []struct {
+		path     string
+		expected qsgpm.RunOption
+		ok       bool
+	}{
+		{
+			path: "testdata/events/register_user.json",
+			expected: qsgpm.RunOption{
+				Namespaces: []string{"default"},
+				Users:      []string{"Analyst/piyo@example.com"},
+			},
+			ok: true,
+		},
+		{
+			path: "testdata/events/update_user.json",
+			expected: qsgpm.RunOption{
+				Namespaces: []string{"tenant-a"},
+				Users:      []string{"alice"},
+			},
+			ok: true,
+		},
+		{
+			path: "testdata/events/create_group.json",
+			ok:   false,
+		},
+		{
+			path:     "testdata/events/scheduled.json",
+			expected: qsgpm.RunOption{},
+			ok:       true,
+		},
+		{
+			path: "testdata/events/custom.json",
+			expected: qsgpm.RunOption{
+				Namespaces: []string{"default"},
+				Users:      []string{"Manager/hoge@example.com"},
+				DryRun:     true,
+			},
+			ok: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			payload, err := os.ReadFile(c.path)
+			require.NoError(t, err)
+			opt, ok, err := qsgpm.ParseLambdaEvent(payload)
+			require.NoError(t, err)
+			require.Equal(t, c.ok, ok)
+			require.Equal(t, c.expected, opt)
+		})
+	}
+
+	opt, ok, err := qsgpm.ParseLambdaEvent(nil)
+	require.NoError(t, err)
+	require.True(t, ok, "an empty payload runs the full sweep")
+	require.Equal(t, qsgpm.RunOption{}, opt)
+
+	_, _, err = qsgpm.ParseLambdaEvent([]byte(`{"detail-type":"AWS API Call via CloudTrail","detail":{"eventSource":"quicksight.amazonaws.com","eventName":"UpdateUser","requestParameters":{}}}`))
+	require.EqualError(t, err, "UpdateUser event has no user name")
+}
+
+func TestAppRunUsers(t *testing.T) {
+	events := make([]*qsgpm.ChangeEvent, 0)
+	app, _ := newTestApp(t, "testdata/config.yaml", "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+		qsgpm.WithChangeEventHandler(func(e *qsgpm.ChangeEvent) {
+			events = append(events, e)
+		}),
+	)
+	summary, err := app.RunWithSummary(context.Background(), qsgpm.RunOption{
+		Users: []string{"Analyst/piyo@example.com", "tora"},
+	})
+	require.NoError(t, err)
+	messages := make([]string, 0, len(events))
+	for _, e := range events {
+		messages = append(messages, e.Message())
+	}
+	require.Equal(t, []string{
+		"update user Analyst/piyo@example.com custom permission: <nil> => analysis by rule rules[2]",
+		"create group analysts",
+		"create group membership Analyst/piyo@example.com in all by rule rules[2]",
+		"create group membership Analyst/piyo@example.com in analysts by rule rules[2]",
+		"create group membership Analyst/piyo@example.com in authors by rule rules[2]",
+		"delete group membership tora in all",
+		"delete group membership tora in legacy",
+	}, messages)
+	require.Equal(t, 2, summary.UsersScanned)
+
+	events = events[:0]
+	_, err = app.RunWithSummary(context.Background(), qsgpm.RunOption{
+		Namespaces: []string{"tenant-a"},
+	})
+	require.NoError(t, err)
+	require.Empty(t, events, "no namespace in scope")
+}
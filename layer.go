@@ -0,0 +1,235 @@
+package qsgpm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	gc "github.com/kayac/go-config"
+	"gopkg.in/yaml.v3"
+)
+
+// RulesMerge is the strategy for merging rules of an overlay config into the base config.
+type RulesMerge string
+
+const (
+	RulesMergeAppend  RulesMerge = "append"
+	RulesMergePrepend RulesMerge = "prepend"
+	RulesMergeReplace RulesMerge = "replace"
+)
+
+func (m RulesMerge) validate() error {
+	switch m {
+	case RulesMergeAppend, RulesMergePrepend, RulesMergeReplace:
+		return nil
+	}
+	return fmt.Errorf("rules_merge %s is not one of %s, %s or %s", m, RulesMergeAppend, RulesMergePrepend, RulesMergeReplace)
+}
+
+// layer directives are consumed while merging, and not decoded into Config.
+const (
+	layerKeyInclude    = "include"
+	layerKeyExtends    = "extends"
+	layerKeyRulesMerge = "rules_merge"
+)
+
+// layer is a config file decoded as a map, whose templates are expanded once when read.
+type layer = map[string]interface{}
+
+// loadLayers reads config files with their includes, and merges them in order.
+func loadLayers(ctx context.Context, sources []ConfigSource) ([]byte, error) {
+	merged := make(layer)
+	for _, src := range sources {
+		l, err := readLayer(ctx, src, nil)
+		if err != nil {
+			return nil, err
+		}
+		if merged, err = mergeLayer(merged, l); err != nil {
+			return nil, fmt.Errorf("%s: %w", src, err)
+		}
+	}
+	return yaml.Marshal(merged)
+}
+
+// readLayer reads a config file, and merges the file over the files it includes.
+func readLayer(ctx context.Context, src ConfigSource, stack []string) (layer, error) {
+	key, err := configSourceKey(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range stack {
+		if k == key {
+			return nil, fmt.Errorf("%s: circular include", src)
+		}
+	}
+	stack = append(stack, key)
+
+	bs, err := src.Read(ctx)
+	if err == nil {
+		bs, err = gc.ReadWithEnvBytes(bs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s read failed: %w", src, err)
+	}
+	l := make(layer)
+	if err := yaml.Unmarshal(bs, &l); err != nil {
+		return nil, fmt.Errorf("%s parse failed: %w", src, err)
+	}
+	includes := make([]string, 0)
+	for _, key := range []string{layerKeyInclude, layerKeyExtends} {
+		values, err := popStrings(l, key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", src, err)
+		}
+		includes = append(includes, values...)
+	}
+	if len(includes) == 0 {
+		return l, nil
+	}
+	merged := make(layer)
+	for _, include := range includes {
+		includeSrc, err := src.Include(include)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", src, err)
+		}
+		base, err := readLayer(ctx, includeSrc, stack)
+		if err != nil {
+			return nil, err
+		}
+		if merged, err = mergeLayer(merged, base); err != nil {
+			return nil, fmt.Errorf("%s: %w", includeSrc, err)
+		}
+	}
+	merged, err = mergeLayer(merged, l)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", src, err)
+	}
+	// keep the strategy for merging this file over the preceding config files.
+	if strategy, ok := l[layerKeyRulesMerge]; ok {
+		merged[layerKeyRulesMerge] = strategy
+	}
+	return merged, nil
+}
+
+// popStrings removes the key from the layer, and returns the value as a string list.
+// A single string is also accepted.
+func popStrings(l layer, key string) ([]string, error) {
+	v, ok := l[key]
+	if !ok {
+		return nil, nil
+	}
+	delete(l, key)
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			str, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a list of strings", key)
+			}
+			values = append(values, str)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("%s must be a string or a list of strings", key)
+}
+
+// mergeLayer merges the overlay into the base.
+// Maps are merged recursively, rules are merged by the rules_merge strategy of the overlay,
+// and the other values are replaced by the overlay.
+func mergeLayer(base, overlay layer) (layer, error) {
+	strategy := RulesMergeAppend
+	if v, ok := overlay[layerKeyRulesMerge]; ok {
+		str, ok := v.(string)
+		if !ok {
+			return nil, errors.New("rules_merge must be a string")
+		}
+		strategy = RulesMerge(str)
+		if err := strategy.validate(); err != nil {
+			return nil, err
+		}
+	}
+	merged := mergeMap(base, overlay, strategy)
+	delete(merged, layerKeyRulesMerge)
+	return merged, nil
+}
+
+func mergeMap(base, overlay layer, strategy RulesMerge) layer {
+	merged := make(layer, len(base)+len(overlay))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overlay {
+		current, ok := merged[k]
+		if !ok {
+			merged[k] = v
+			continue
+		}
+		if k == "rules" {
+			if baseRules, ok := current.([]interface{}); ok {
+				if overlayRules, ok := v.([]interface{}); ok {
+					merged[k] = mergeRules(baseRules, overlayRules, strategy)
+					continue
+				}
+			}
+		}
+		if baseMap, ok := current.(layer); ok {
+			if overlayMap, ok := v.(layer); ok {
+				merged[k] = mergeMap(baseMap, overlayMap, strategy)
+				continue
+			}
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+// mergeRules merges the overlay rules into the base rules.
+// An overlay rule with the same id as a base rule replaces the base rule in place,
+// and the other overlay rules are merged by the strategy.
+func mergeRules(base, overlay []interface{}, strategy RulesMerge) []interface{} {
+	if strategy != RulesMergeReplace {
+		index := make(map[string]int, len(base))
+		for i, rule := range base {
+			if id := ruleID(rule); id != "" {
+				index[id] = i
+			}
+		}
+		replaced := make([]interface{}, len(base))
+		copy(replaced, base)
+		rest := make([]interface{}, 0, len(overlay))
+		for _, rule := range overlay {
+			if i, ok := index[ruleID(rule)]; ok {
+				replaced[i] = rule
+				continue
+			}
+			rest = append(rest, rule)
+		}
+		base, overlay = replaced, rest
+	}
+	merged := make([]interface{}, 0, len(base)+len(overlay))
+	switch strategy {
+	case RulesMergePrepend:
+		merged = append(merged, overlay...)
+		merged = append(merged, base...)
+	case RulesMergeReplace:
+		merged = append(merged, overlay...)
+	default:
+		merged = append(merged, base...)
+		merged = append(merged, overlay...)
+	}
+	return merged
+}
+
+func ruleID(rule interface{}) string {
+	m, ok := rule.(layer)
+	if !ok {
+		return ""
+	}
+	id, _ := m["id"].(string)
+	return id
+}
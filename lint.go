@@ -0,0 +1,159 @@
+package qsgpm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/quicksight/types"
+)
+
+// LintIssue is a problem of a rule found by static analysis.
+type LintIssue struct {
+	Rule    string
+	Message string
+}
+
+func (issue *LintIssue) String() string {
+	return fmt.Sprintf("%s: %s", issue.Rule, issue.Message)
+}
+
+// Lint analyses the rules symbolically, and reports the rules which can never match,
+// duplicate rules, rules shadowed by earlier rules, rules which do nothing and expired rules.
+// Since the rules are evaluated from the top and the first matching rule is applied,
+// a rule is shadowed if an earlier rule matches all users the rule matches.
+func (cfg *Config) Lint() []*LintIssue {
+	issues := lintRules(cfg.Rules, len(cfg.Rules))
+	namespaces := make([]string, 0, len(cfg.Namespaces))
+	for namespace := range cfg.Namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		ns := cfg.Namespaces[namespace]
+		// top-level rules following the namespace rules are checked as the top-level rules.
+		issues = append(issues, lintRules(ns.rules, len(ns.Rules))...)
+	}
+	return issues
+}
+
+// lintRules checks the first n rules, with the earlier rules in the list.
+func lintRules(rules []*RuleConfig, n int) []*LintIssue {
+	issues := make([]*LintIssue, 0)
+	now := time.Now()
+	report := func(rule *RuleConfig, format string, args ...interface{}) {
+		issues = append(issues, &LintIssue{
+			Rule:    rule.String(),
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+RULES:
+	for i := 0; i < n; i++ {
+		rule := rules[i]
+		if rule.expired(now) {
+			report(rule, "expired at %s", rule.expiresAt.Format(time.RFC3339))
+			continue
+		}
+		if reason := rule.User.conflict(); reason != "" {
+			report(rule, "never matches, %s", reason)
+			continue
+		}
+		hasGroups := len(rule.Groups) > 0
+		hasCustomPermission := rule.CustomPermission != ""
+		if !hasGroups && !hasCustomPermission {
+			report(rule, "has no groups and no custom_permission")
+			continue
+		}
+		var groupsShadowedBy, customPermissionShadowedBy *RuleConfig
+		for j := 0; j < i; j++ {
+			prev := rules[j]
+			if prev.expired(now) || prev.User.conflict() != "" || !prev.User.covers(rule.User) {
+				continue
+			}
+			if rule.User.covers(prev.User) && prev.CustomPermission == rule.CustomPermission && sameStrings(prev.Groups, rule.Groups) {
+				report(rule, "duplicate of %s", prev)
+				continue RULES
+			}
+			if groupsShadowedBy == nil && len(prev.Groups) > 0 {
+				groupsShadowedBy = prev
+			}
+			if customPermissionShadowedBy == nil && prev.CustomPermission != "" {
+				customPermissionShadowedBy = prev
+			}
+		}
+		groupsApplied := hasGroups && groupsShadowedBy == nil
+		customPermissionApplied := hasCustomPermission && customPermissionShadowedBy == nil
+		switch {
+		case !groupsApplied && !customPermissionApplied:
+			shadows := make([]string, 0, 2)
+			for _, shadow := range []*RuleConfig{groupsShadowedBy, customPermissionShadowedBy} {
+				if shadow != nil && (len(shadows) == 0 || shadows[0] != shadow.String()) {
+					shadows = append(shadows, shadow.String())
+				}
+			}
+			report(rule, "is unreachable, shadowed by %s", strings.Join(shadows, " and "))
+		case hasGroups && !groupsApplied:
+			report(rule, "groups are never applied, shadowed by %s", groupsShadowedBy)
+		case hasCustomPermission && !customPermissionApplied:
+			report(rule, "custom_permission is never applied, shadowed by %s", customPermissionShadowedBy)
+		}
+	}
+	return issues
+}
+
+// conflict returns the reason why no user can match the conditions, or empty if some user can.
+func (cfg *UserConfig) conflict() string {
+	if cfg.identityType != "" && cfg.identityType != types.IdentityTypeIam {
+		if cfg.SessionNameSuffix != "" {
+			return fmt.Sprintf("session_name_suffix is only for IAM users but identity_type is %s", cfg.identityType)
+		}
+		if cfg.IAMRoleName != "" {
+			return fmt.Sprintf("iam_role_name is only for IAM users but identity_type is %s", cfg.identityType)
+		}
+	}
+	return ""
+}
+
+// covers reports whether all users matching the other conditions also match the conditions.
+func (cfg *UserConfig) covers(other *UserConfig) bool {
+	if cfg.identityType != "" && cfg.identityType != other.identityType {
+		// iam_role_name and session_name_suffix match only IAM users.
+		implied := cfg.identityType == types.IdentityTypeIam && (other.IAMRoleName != "" || other.SessionNameSuffix != "")
+		if !implied {
+			return false
+		}
+	}
+	if cfg.role != "" && cfg.role != other.role {
+		return false
+	}
+	if cfg.Namespace != "" && cfg.Namespace != other.Namespace {
+		return false
+	}
+	if cfg.IAMRoleName != "" && cfg.IAMRoleName != other.IAMRoleName {
+		return false
+	}
+	if !strings.HasSuffix(other.SessionNameSuffix, cfg.SessionNameSuffix) {
+		return false
+	}
+	if !strings.HasSuffix(other.EmailSuffix, cfg.EmailSuffix) {
+		return false
+	}
+	return true
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	m := make(map[string]struct{}, len(a))
+	for _, s := range a {
+		m[s] = struct{}{}
+	}
+	for _, s := range b {
+		if _, ok := m[s]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package qsgpm_test
+
+import (
+	"testing"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigLint(t *testing.T) {
+	cases := []struct {
+		filepath  string
+		excpected []string
+	}{
+		{
+			filepath: "testdata/config.yaml",
+			excpected: []string{
+				"rules[4]: duplicate of rules[3]",
+			},
+		},
+		{
+			filepath: "testdata/config_quicksight.yaml",
+			excpected: []string{
+				"rules[3]: never matches, session_name_suffix is only for IAM users but identity_type is QUICKSIGHT",
+			},
+		},
+		{
+			filepath: "testdata/lint.yaml",
+			excpected: []string{
+				"manager: groups are never applied, shadowed by author",
+				"author-email: is unreachable, shadowed by author",
+				"external: never matches, iam_role_name is only for IAM users but identity_type is QUICKSIGHT",
+				"nothing: has no groups and no custom_permission",
+				"admin-again: duplicate of admin",
+				"tenant-a-reader: groups are never applied, shadowed by tenant-a-all",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.filepath, func(t *testing.T) {
+			cfg := qsgpm.NewDefaultConfig()
+			err := cfg.Load(c.filepath)
+			require.NoError(t, err)
+			actual := make([]string, 0)
+			for _, issue := range cfg.Lint() {
+				actual = append(actual, issue.String())
+			}
+			require.Equal(t, c.excpected, actual)
+		})
+	}
+}
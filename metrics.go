@@ -0,0 +1,322 @@
+package qsgpm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+)
+
+// latencyBuckets are the upper bounds of the API latency histogram in seconds.
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// emfMaxValues is the maximum number of values of a metric in an EMF record.
+const emfMaxValues = 100
+
+// Metrics collects the metrics of the runs, such as the changes by type and the API latency per operation.
+type Metrics struct {
+	mu           sync.Mutex
+	changes      map[changeMetricsKey]int
+	changeErrors map[ChangeType]int
+	operations   map[string]*operationMetrics
+	runs         int
+	runErrors    int
+	lastRun      *RunSummary
+}
+
+type changeMetricsKey struct {
+	changeType ChangeType
+	dryRun     bool
+}
+
+type operationMetrics struct {
+	calls      int
+	errors     int
+	throttles  int
+	latencySum float64
+	// buckets are the counts of the calls with the latency less than or equal to each of latencyBuckets.
+	buckets   []int
+	latencies []float64
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	m := &Metrics{}
+	m.Reset()
+	return m
+}
+
+// Reset clears the collected metrics, e.g. after written in EMF.
+func (m *Metrics) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.changes = make(map[changeMetricsKey]int)
+	m.changeErrors = make(map[ChangeType]int)
+	m.operations = make(map[string]*operationMetrics)
+	m.runs = 0
+	m.runErrors = 0
+	m.lastRun = nil
+}
+
+func (m *Metrics) observeAPI(operation string, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	op, ok := m.operations[operation]
+	if !ok {
+		op = &operationMetrics{
+			buckets: make([]int, len(latencyBuckets)),
+		}
+		m.operations[operation] = op
+	}
+	seconds := latency.Seconds()
+	op.calls++
+	op.latencySum += seconds
+	op.latencies = append(op.latencies, seconds)
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			op.buckets[i]++
+		}
+	}
+	if err != nil {
+		op.errors++
+		if isThrottle(err) {
+			op.throttles++
+		}
+	}
+}
+
+func (m *Metrics) observeChange(e *ChangeEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e.Error != nil {
+		m.changeErrors[e.Type]++
+		return
+	}
+	m.changes[changeMetricsKey{changeType: e.Type, dryRun: e.DryRun}]++
+}
+
+func (m *Metrics) observeRun(summary *RunSummary, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs++
+	if err != nil {
+		m.runErrors++
+	}
+	m.lastRun = summary
+}
+
+// isThrottle reports whether the error is caused by the API throttling.
+func isThrottle(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	_, ok := retry.DefaultThrottleErrorCodes[apiErr.ErrorCode()]
+	return ok
+}
+
+func (m *Metrics) operationNames() []string {
+	names := make([]string, 0, len(m.operations))
+	for name := range m.operations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *Metrics) changeKeys() []changeMetricsKey {
+	keys := make([]changeMetricsKey, 0, len(m.changes))
+	for key := range m.changes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].changeType != keys[j].changeType {
+			return keys[i].changeType < keys[j].changeType
+		}
+		return !keys[i].dryRun && keys[j].dryRun
+	})
+	return keys
+}
+
+func (m *Metrics) changeErrorTypes() []ChangeType {
+	types := make([]ChangeType, 0, len(m.changeErrors))
+	for t := range m.changeErrors {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i] < types[j]
+	})
+	return types
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format, e.g. for the textfile collector of node_exporter.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b strings.Builder
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	formatFloat := func(f float64) string {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+
+	metric("qsgpm_changes_total", "counter", "Number of changes applied to QuickSight.")
+	for _, key := range m.changeKeys() {
+		fmt.Fprintf(&b, "qsgpm_changes_total{type=%q,dry_run=\"%t\"} %d\n", key.changeType, key.dryRun, m.changes[key])
+	}
+	metric("qsgpm_change_errors_total", "counter", "Number of changes failed to apply.")
+	for _, t := range m.changeErrorTypes() {
+		fmt.Fprintf(&b, "qsgpm_change_errors_total{type=%q} %d\n", t, m.changeErrors[t])
+	}
+
+	operations := m.operationNames()
+	metric("qsgpm_api_calls_total", "counter", "Number of QuickSight API calls.")
+	for _, name := range operations {
+		fmt.Fprintf(&b, "qsgpm_api_calls_total{operation=%q} %d\n", name, m.operations[name].calls)
+	}
+	metric("qsgpm_api_errors_total", "counter", "Number of QuickSight API calls failed.")
+	for _, name := range operations {
+		fmt.Fprintf(&b, "qsgpm_api_errors_total{operation=%q} %d\n", name, m.operations[name].errors)
+	}
+	metric("qsgpm_api_throttles_total", "counter", "Number of QuickSight API calls failed by throttling.")
+	for _, name := range operations {
+		fmt.Fprintf(&b, "qsgpm_api_throttles_total{operation=%q} %d\n", name, m.operations[name].throttles)
+	}
+	metric("qsgpm_api_latency_seconds", "histogram", "Latency of QuickSight API calls.")
+	for _, name := range operations {
+		op := m.operations[name]
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(&b, "qsgpm_api_latency_seconds_bucket{operation=%q,le=%q} %d\n", name, formatFloat(le), op.buckets[i])
+		}
+		fmt.Fprintf(&b, "qsgpm_api_latency_seconds_bucket{operation=%q,le=\"+Inf\"} %d\n", name, op.calls)
+		fmt.Fprintf(&b, "qsgpm_api_latency_seconds_sum{operation=%q} %s\n", name, formatFloat(op.latencySum))
+		fmt.Fprintf(&b, "qsgpm_api_latency_seconds_count{operation=%q} %d\n", name, op.calls)
+	}
+
+	metric("qsgpm_runs_total", "counter", "Number of runs.")
+	fmt.Fprintf(&b, "qsgpm_runs_total %d\n", m.runs)
+	metric("qsgpm_run_errors_total", "counter", "Number of runs failed.")
+	fmt.Fprintf(&b, "qsgpm_run_errors_total %d\n", m.runErrors)
+	if m.lastRun != nil {
+		metric("qsgpm_last_run_timestamp_seconds", "gauge", "Unix time of the start of the last run.")
+		fmt.Fprintf(&b, "qsgpm_last_run_timestamp_seconds %d\n", m.lastRun.StartedAt.Unix())
+		metric("qsgpm_last_run_duration_seconds", "gauge", "Duration of the last run.")
+		fmt.Fprintf(&b, "qsgpm_last_run_duration_seconds %s\n", formatFloat(m.lastRun.Elapsed.Seconds()))
+		metric("qsgpm_last_run_errors", "gauge", "Number of errors in the last run.")
+		fmt.Fprintf(&b, "qsgpm_last_run_errors %d\n", m.lastRun.Errors)
+		metric("qsgpm_last_run_users", "gauge", "Number of users scanned in the last run.")
+		fmt.Fprintf(&b, "qsgpm_last_run_users{state=\"matched\"} %d\n", m.lastRun.UsersMatched)
+		fmt.Fprintf(&b, "qsgpm_last_run_users{state=\"unmatched\"} %d\n", m.lastRun.UsersUnmatched)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+// WriteEMF writes the metrics as CloudWatch Embedded Metric Format records, one JSON per line.
+func (m *Metrics) WriteEMF(w io.Writer, namespace string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	timestamp := time.Now().UnixMilli()
+	enc := json.NewEncoder(w)
+	write := func(dimensions []string, metrics []emfMetric, values map[string]interface{}) error {
+		record := map[string]interface{}{
+			"_aws": map[string]interface{}{
+				"Timestamp": timestamp,
+				"CloudWatchMetrics": []map[string]interface{}{{
+					"Namespace":  namespace,
+					"Dimensions": [][]string{dimensions},
+					"Metrics":    metrics,
+				}},
+			},
+		}
+		for key, value := range values {
+			record[key] = value
+		}
+		return enc.Encode(record)
+	}
+
+	for _, name := range m.operationNames() {
+		op := m.operations[name]
+		latencies := make([]float64, 0, len(op.latencies))
+		for _, seconds := range op.latencies {
+			latencies = append(latencies, seconds*1000)
+		}
+		// the latencies are split into records, since a record can have at most 100 values of a metric.
+		for i := 0; i == 0 || i < len(latencies); i += emfMaxValues {
+			values := map[string]interface{}{
+				"Operation": name,
+			}
+			metrics := make([]emfMetric, 0, 4)
+			if i == 0 {
+				metrics = append(metrics,
+					emfMetric{Name: "APICalls", Unit: "Count"},
+					emfMetric{Name: "APIErrors", Unit: "Count"},
+					emfMetric{Name: "APIThrottles", Unit: "Count"},
+				)
+				values["APICalls"] = op.calls
+				values["APIErrors"] = op.errors
+				values["APIThrottles"] = op.throttles
+			}
+			if len(latencies) > 0 {
+				metrics = append(metrics, emfMetric{Name: "APILatency", Unit: "Milliseconds"})
+				values["APILatency"] = latencies[i:minInt(i+emfMaxValues, len(latencies))]
+			}
+			if err := write([]string{"Operation"}, metrics, values); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range m.changeKeys() {
+		if err := write([]string{"ChangeType", "DryRun"}, []emfMetric{{Name: "Changes", Unit: "Count"}}, map[string]interface{}{
+			"ChangeType": string(key.changeType),
+			"DryRun":     strconv.FormatBool(key.dryRun),
+			"Changes":    m.changes[key],
+		}); err != nil {
+			return err
+		}
+	}
+	for _, changeType := range m.changeErrorTypes() {
+		if err := write([]string{"ChangeType"}, []emfMetric{{Name: "ChangeErrors", Unit: "Count"}}, map[string]interface{}{
+			"ChangeType":   string(changeType),
+			"ChangeErrors": m.changeErrors[changeType],
+		}); err != nil {
+			return err
+		}
+	}
+	if m.runs == 0 {
+		return nil
+	}
+	values := map[string]interface{}{
+		"Runs":      m.runs,
+		"RunErrors": m.runErrors,
+	}
+	metrics := []emfMetric{
+		{Name: "Runs", Unit: "Count"},
+		{Name: "RunErrors", Unit: "Count"},
+	}
+	if m.lastRun != nil {
+		metrics = append(metrics,
+			emfMetric{Name: "RunDuration", Unit: "Seconds"},
+			emfMetric{Name: "UsersMatched", Unit: "Count"},
+			emfMetric{Name: "UsersUnmatched", Unit: "Count"},
+		)
+		values["RunDuration"] = m.lastRun.Elapsed.Seconds()
+		values["UsersMatched"] = m.lastRun.UsersMatched
+		values["UsersUnmatched"] = m.lastRun.UsersUnmatched
+	}
+	return write([]string{}, metrics, values)
+}
@@ -0,0 +1,77 @@
+package qsgpm_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := qsgpm.NewMetrics()
+	app, _ := newTestApp(t, "testdata/config.yaml", "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+		qsgpm.WithMetrics(metrics),
+	)
+	ctx := context.Background()
+	require.NoError(t, app.Run(ctx, qsgpm.RunOption{DryRun: true}))
+	require.NoError(t, app.Run(ctx, qsgpm.RunOption{}))
+
+	var buf bytes.Buffer
+	require.NoError(t, metrics.WritePrometheus(&buf))
+	for _, line := range []string{
+		`qsgpm_changes_total{type="add_membership",dry_run="false"} 5`,
+		`qsgpm_changes_total{type="add_membership",dry_run="true"} 5`,
+		`qsgpm_changes_total{type="create_group",dry_run="false"} 2`,
+		`qsgpm_api_calls_total{operation="ListUsers"} 2`,
+		`qsgpm_api_calls_total{operation="CreateGroupMembership"} 5`,
+		`qsgpm_api_errors_total{operation="ListUsers"} 0`,
+		`qsgpm_api_latency_seconds_count{operation="ListGroupMemberships"} 8`,
+		`qsgpm_api_latency_seconds_bucket{operation="ListGroupMemberships",le="+Inf"} 8`,
+		`qsgpm_runs_total 2`,
+		`qsgpm_run_errors_total 0`,
+		`qsgpm_last_run_users{state="matched"} 3`,
+	} {
+		require.Contains(t, buf.String(), line+"\n")
+	}
+
+	buf.Reset()
+	require.NoError(t, metrics.WriteEMF(&buf, "qsgpm"))
+	scanner := bufio.NewScanner(&buf)
+	operations := make(map[string]float64)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		require.Contains(t, record, "_aws")
+		if op, ok := record["Operation"].(string); ok {
+			operations[op] = record["APICalls"].(float64)
+		}
+	}
+	require.Equal(t, map[string]float64{
+		"ListUsers":             2,
+		"ListGroups":            2,
+		"ListGroupMemberships":  8,
+		"UpdateUser":            2,
+		"CreateGroup":           2,
+		"CreateGroupMembership": 5,
+		"DeleteGroupMembership": 2,
+		"DeleteGroup":           1,
+	}, operations)
+
+	metrics.Reset()
+	app, fake := newTestApp(t, "testdata/config.yaml", "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+		qsgpm.WithMetrics(metrics),
+	)
+	fake.failOn = "CreateGroup"
+	require.Error(t, app.Run(ctx, qsgpm.RunOption{}))
+	buf.Reset()
+	require.NoError(t, metrics.WritePrometheus(&buf))
+	require.Contains(t, buf.String(), `qsgpm_change_errors_total{type="create_group"} 1`+"\n")
+	require.Contains(t, buf.String(), `qsgpm_api_errors_total{operation="CreateGroup"} 1`+"\n")
+	require.Contains(t, buf.String(), "qsgpm_run_errors_total 1\n")
+}
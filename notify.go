@@ -0,0 +1,306 @@
+package qsgpm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NotificationEvent is what a notification tells.
+type NotificationEvent string
+
+const (
+	NotifyChanges NotificationEvent = "changes"
+	NotifyDrift   NotificationEvent = "drift"
+	NotifyFailure NotificationEvent = "failure"
+)
+
+// NotificationPreset is the payload format of a webhook.
+type NotificationPreset string
+
+const (
+	PresetGeneric NotificationPreset = "generic"
+	PresetSlack   NotificationPreset = "slack"
+	PresetTeams   NotificationPreset = "teams"
+)
+
+// defaultNotificationTemplate is the text of a notification if the template is omitted.
+const defaultNotificationTemplate = "${title}\n${details}"
+
+// notificationVariables are the variables expanded in the template.
+var notificationVariables = []string{"event", "title", "summary", "details", "count", "error", "dry_run"}
+
+// NotificationConfig is a webhook notified of the applied changes, the detected drift or the failures.
+type NotificationConfig struct {
+	Name    string             `yaml:"name"`
+	URL     string             `yaml:"url"`
+	Preset  NotificationPreset `yaml:"preset"`
+	Events  []string           `yaml:"events"`
+	Headers map[string]string  `yaml:"headers"`
+	// Template is the text of the notification, in which ${title}, ${details} and so on are expanded.
+	Template string `yaml:"template"`
+	// MinChanges and MinDrifts are the thresholds to notify, 1 if omitted.
+	MinChanges *int `yaml:"min_changes"`
+	MinDrifts  *int `yaml:"min_drifts"`
+
+	events map[NotificationEvent]bool
+}
+
+func (cfg *NotificationConfig) Restrict() error {
+	errs := make([]error, 0)
+	if cfg.URL == "" {
+		errs = append(errs, errors.New("url is required"))
+	} else if u, err := url.Parse(cfg.URL); err != nil {
+		errs = append(errs, fmt.Errorf("url: %w", err))
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("url %s is not an http(s) URL", cfg.URL))
+	}
+	switch cfg.Preset {
+	case "":
+		cfg.Preset = PresetGeneric
+	case PresetGeneric, PresetSlack, PresetTeams:
+	default:
+		errs = append(errs, fmt.Errorf("preset %s is not one of %s, %s or %s", cfg.Preset, PresetGeneric, PresetSlack, PresetTeams))
+	}
+	cfg.events = make(map[NotificationEvent]bool, 3)
+	if len(cfg.Events) == 0 {
+		cfg.Events = []string{string(NotifyChanges), string(NotifyDrift), string(NotifyFailure)}
+	}
+	for _, event := range cfg.Events {
+		switch e := NotificationEvent(event); e {
+		case NotifyChanges, NotifyDrift, NotifyFailure:
+			cfg.events[e] = true
+		default:
+			errs = append(errs, fmt.Errorf("event %s is not one of %s, %s or %s", event, NotifyChanges, NotifyDrift, NotifyFailure))
+		}
+	}
+	if cfg.Template == "" {
+		cfg.Template = defaultNotificationTemplate
+	}
+	os.Expand(cfg.Template, func(key string) string {
+		for _, v := range notificationVariables {
+			if key == v {
+				return ""
+			}
+		}
+		errs = append(errs, fmt.Errorf("template: unknown variable ${%s}", key))
+		return ""
+	})
+	if cfg.MinChanges != nil && *cfg.MinChanges < 0 {
+		errs = append(errs, errors.New("min_changes must not be negative"))
+	}
+	if cfg.MinDrifts != nil && *cfg.MinDrifts < 0 {
+		errs = append(errs, errors.New("min_drifts must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// String returns the name of the notification, or the host of the URL.
+func (cfg *NotificationConfig) String() string {
+	if cfg.Name != "" {
+		return cfg.Name
+	}
+	if u, err := url.Parse(cfg.URL); err == nil {
+		return u.Host
+	}
+	return cfg.URL
+}
+
+func (cfg *NotificationConfig) threshold(event NotificationEvent) int {
+	var threshold *int
+	switch event {
+	case NotifyChanges:
+		threshold = cfg.MinChanges
+	case NotifyDrift:
+		threshold = cfg.MinDrifts
+	}
+	if threshold == nil {
+		return 1
+	}
+	return *threshold
+}
+
+// Notification is the content of a notification.
+type Notification struct {
+	Event   NotificationEvent `json:"event"`
+	Title   string            `json:"title"`
+	Text    string            `json:"text"`
+	Count   int               `json:"count"`
+	DryRun  bool              `json:"dry_run,omitempty"`
+	Summary *RunSummary       `json:"summary,omitempty"`
+	Drifts  []*Drift          `json:"drifts,omitempty"`
+	Error   string            `json:"error,omitempty"`
+
+	details []string
+}
+
+// NewRunNotification describes the run, as a failure if err is not nil.
+func NewRunNotification(summary *RunSummary, changes []*ChangeEvent, err error) *Notification {
+	n := &Notification{
+		Event:   NotifyChanges,
+		DryRun:  summary.DryRun,
+		Summary: summary,
+		details: make([]string, 0, len(changes)),
+	}
+	for _, e := range changes {
+		if e.Error == nil {
+			n.Count++
+		}
+		if e.Target != "" {
+			n.details = append(n.details, fmt.Sprintf("[%s/%s] %s", e.Target, e.Namespace, e.Message()))
+		} else {
+			n.details = append(n.details, fmt.Sprintf("[%s] %s", e.Namespace, e.Message()))
+		}
+	}
+	verb := "applied"
+	if summary.DryRun {
+		verb = "planned"
+	}
+	n.Title = fmt.Sprintf("qsgpm %s %d change(s)", verb, n.Count)
+	if err != nil {
+		n.Event = NotifyFailure
+		n.Error = err.Error()
+		n.Title = fmt.Sprintf("qsgpm failed after %d change(s): %s", n.Count, err)
+	}
+	if summary.DryRun {
+		n.Title += " (dry run)"
+	}
+	return n
+}
+
+// NewDriftNotification describes the drift report.
+func NewDriftNotification(report *DriftReport) *Notification {
+	n := &Notification{
+		Event:   NotifyDrift,
+		Count:   len(report.Drifts),
+		Title:   fmt.Sprintf("qsgpm detected %d drift(s)", len(report.Drifts)),
+		Drifts:  report.Drifts,
+		details: make([]string, 0, len(report.Drifts)),
+	}
+	for _, d := range report.Drifts {
+		n.details = append(n.details, d.String())
+	}
+	return n
+}
+
+func (n *Notification) expand(template string) string {
+	var summary string
+	if n.Summary != nil {
+		summary = n.Summary.String()
+	}
+	return strings.TrimSpace(os.Expand(template, func(key string) string {
+		switch key {
+		case "event":
+			return string(n.Event)
+		case "title":
+			return n.Title
+		case "summary":
+			return summary
+		case "details":
+			return strings.Join(n.details, "\n")
+		case "count":
+			return strconv.Itoa(n.Count)
+		case "error":
+			return n.Error
+		case "dry_run":
+			return strconv.FormatBool(n.DryRun)
+		}
+		return ""
+	}))
+}
+
+// payload returns the body of the webhook request in the preset format.
+func (n *Notification) payload(cfg *NotificationConfig) interface{} {
+	text := n.expand(cfg.Template)
+	switch cfg.Preset {
+	case PresetSlack:
+		return map[string]interface{}{
+			"text": text,
+		}
+	case PresetTeams:
+		return map[string]interface{}{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  n.Title,
+			"title":    n.Title,
+			// Teams renders the text as Markdown, in which a single newline is not a line break.
+			"text": strings.ReplaceAll(text, "\n", "\n\n"),
+		}
+	}
+	generic := *n
+	generic.Text = text
+	return &generic
+}
+
+// Notifier posts the notifications to the webhooks.
+type Notifier struct {
+	cfgs   []*NotificationConfig
+	client *http.Client
+}
+
+// NewNotifier returns the notifier for the notifications section.
+func NewNotifier(cfgs []*NotificationConfig) *Notifier {
+	return &Notifier{
+		cfgs: cfgs,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// Notify posts the notification to the webhooks which subscribe the event and whose threshold is reached.
+// It tries all webhooks, and returns the joined errors.
+func (n *Notifier) Notify(ctx context.Context, notification *Notification) error {
+	errs := make([]error, 0)
+	for _, cfg := range n.cfgs {
+		if !cfg.events[notification.Event] {
+			continue
+		}
+		if notification.Event != NotifyFailure && notification.Count < cfg.threshold(notification.Event) {
+			log.Printf("[debug] notification %s skipped: %d < threshold %d", cfg, notification.Count, cfg.threshold(notification.Event))
+			continue
+		}
+		if err := n.post(ctx, cfg, notification.payload(cfg)); err != nil {
+			errs = append(errs, fmt.Errorf("notification %s: %w", cfg, err))
+			continue
+		}
+		log.Printf("[info] notified %s of %s", cfg, notification.Event)
+	}
+	return errors.Join(errs...)
+}
+
+func (n *Notifier) post(ctx context.Context, cfg *NotificationConfig, payload interface{}) error {
+	bs, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "qsgpm")
+	for key, value := range cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package qsgpm_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+type webhookRequest struct {
+	path    string
+	header  http.Header
+	payload map[string]interface{}
+}
+
+func newWebhookServer(t *testing.T) (*httptest.Server, func() []*webhookRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	requests := make([]*webhookRequest, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var payload map[string]interface{}
+		require.NoError(t, json.Unmarshal(bs, &payload))
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, &webhookRequest{
+			path:    r.URL.Path,
+			header:  r.Header,
+			payload: payload,
+		})
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("QSGPM_TEST_WEBHOOK_URL", server.URL)
+	return server, func() []*webhookRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		received := requests
+		requests = make([]*webhookRequest, 0)
+		return received
+	}
+}
+
+func TestAppRunNotifications(t *testing.T) {
+	_, received := newWebhookServer(t)
+	app, _ := newTestApp(t, "testdata/config_notifications.yaml", "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+	)
+	ctx := context.Background()
+
+	require.NoError(t, app.Run(ctx, qsgpm.RunOption{DryRun: true}))
+	require.Empty(t, received(), "dry runs are not notified")
+
+	require.NoError(t, app.Run(ctx, qsgpm.RunOption{}))
+	requests := received()
+	require.Len(t, requests, 2)
+	require.Equal(t, "/slack", requests[0].path)
+	require.Equal(t, "application/json", requests[0].header.Get("Content-Type"))
+	text := requests[0].payload["text"].(string)
+	require.Contains(t, text, "qsgpm applied 12 change(s)\n")
+	require.Contains(t, text, "[default] create group analysts\n")
+	require.Equal(t, "/generic", requests[1].path)
+	require.Equal(t, "Bearer secret", requests[1].header.Get("Authorization"))
+	require.Equal(t, "changes: 12", requests[1].payload["text"])
+	require.Equal(t, "changes", requests[1].payload["event"])
+	require.EqualValues(t, 5, requests[1].payload["summary"].(map[string]interface{})["memberships_added"])
+
+	require.NoError(t, app.Run(ctx, qsgpm.RunOption{}))
+	require.Empty(t, received(), "nothing changed")
+}
+
+func TestAppRunNotificationsFailure(t *testing.T) {
+	_, received := newWebhookServer(t)
+	app, fake := newTestApp(t, "testdata/config_notifications.yaml", "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+	)
+	fake.failOn = "CreateGroup"
+	require.Error(t, app.Run(context.Background(), qsgpm.RunOption{}))
+	requests := received()
+	require.Len(t, requests, 2)
+	require.Equal(t, "/slack", requests[0].path)
+	require.Equal(t, "qsgpm failed after 2 change(s): CreateGroup failed\n"+
+		"[default] update user Analyst/piyo@example.com custom permission: <nil> => analysis by rule rules[2]\n"+
+		"[default] update user Manager/hoge@example.com custom permission: analysis => manager by rule rules[1]\n"+
+		"[default] failed to create group analysts: CreateGroup failed",
+		requests[0].payload["text"],
+	)
+	require.Equal(t, "failure: 2 CreateGroup failed", requests[1].payload["text"])
+}
+
+func TestNotifyDrift(t *testing.T) {
+	_, received := newWebhookServer(t)
+	cfg := qsgpm.NewDefaultConfig()
+	require.NoError(t, cfg.Load("testdata/config_notifications.yaml"))
+	snapshot, err := qsgpm.LoadSnapshot("testdata/snapshots/snapshot.json")
+	require.NoError(t, err)
+	plan, err := cfg.PlanSnapshot(snapshot)
+	require.NoError(t, err)
+	report := qsgpm.NewDriftReport(plan)
+
+	notifier := qsgpm.NewNotifier(cfg.Notifications)
+	require.NoError(t, notifier.Notify(context.Background(), qsgpm.NewDriftNotification(report)))
+	requests := received()
+	require.Len(t, requests, 2)
+	require.Equal(t, "/teams", requests[0].path)
+	require.Equal(t, "MessageCard", requests[0].payload["@type"])
+	require.Equal(t, "qsgpm detected 12 drift(s)", requests[0].payload["title"])
+	require.Contains(t, requests[0].payload["text"], "\n\n[default] group analysts does not exist\n\n")
+	require.Equal(t, "/generic", requests[1].path)
+	require.Equal(t, "drift: 12", requests[1].payload["text"])
+	require.Len(t, requests[1].payload["drifts"], 12)
+
+	require.NoError(t, notifier.Notify(context.Background(), qsgpm.NewDriftNotification(&qsgpm.DriftReport{})))
+	require.Empty(t, received(), "no drift")
+}
@@ -0,0 +1,124 @@
+package qsgpm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// OverrideConfig adds or removes groups of a user, or pins the custom permission of the user,
+// after the rules and the exceptions are evaluated.
+// Overrides are keyed by the user name or the email of the user.
+type OverrideConfig struct {
+	// Namespace limits the override to the namespace, all namespaces if empty.
+	Namespace        string   `yaml:"namespace"`
+	AddGroups        []string `yaml:"add_groups"`
+	RemoveGroups     []string `yaml:"remove_groups"`
+	CustomPermission string   `yaml:"custom_permission"`
+	Reason           string   `yaml:"reason"`
+
+	key   string
+	label string
+}
+
+func (cfg *OverrideConfig) Restrict(key string) error {
+	cfg.key = key
+	cfg.label = fmt.Sprintf("overrides[%s]", key)
+	errs := make([]error, 0)
+	if strings.TrimSpace(key) == "" {
+		errs = append(errs, errors.New("user name or email is required as the key"))
+	}
+	if cfg.Reason == "" {
+		errs = append(errs, errors.New("reason is required"))
+	}
+	if len(cfg.AddGroups) == 0 && len(cfg.RemoveGroups) == 0 && cfg.CustomPermission == "" {
+		errs = append(errs, errors.New("add_groups, remove_groups or custom_permission is required"))
+	}
+	for _, g := range cfg.AddGroups {
+		if containsString(cfg.RemoveGroups, g) {
+			errs = append(errs, fmt.Errorf("group %s is in both add_groups and remove_groups", g))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// String returns the key of the override in the config.
+func (cfg *OverrideConfig) String() string {
+	return cfg.label
+}
+
+// Match reports whether the override is for the user.
+func (cfg *OverrideConfig) Match(user *User) bool {
+	if cfg.Namespace != "" && cfg.Namespace != user.Namespace {
+		return false
+	}
+	return cfg.key == aws.ToString(user.UserName) || strings.EqualFold(cfg.key, aws.ToString(user.Email))
+}
+
+// addGroups returns the groups with the added groups of the override.
+func (cfg *OverrideConfig) addGroups(groups []string) []string {
+	added := make([]string, 0, len(groups)+len(cfg.AddGroups))
+	added = append(added, groups...)
+	for _, g := range cfg.AddGroups {
+		if !containsString(added, g) {
+			added = append(added, g)
+		}
+	}
+	return added
+}
+
+// removeGroups returns the groups without the removed groups of the override.
+func (cfg *OverrideConfig) removeGroups(groups []string) []string {
+	removed := make([]string, 0, len(groups))
+	for _, g := range groups {
+		if !containsString(cfg.RemoveGroups, g) {
+			removed = append(removed, g)
+		}
+	}
+	return removed
+}
+
+// matchOverride returns the override for the user.
+// The override keyed by the user name takes priority over the one keyed by the email.
+func (cfg *Config) matchOverride(user *User) (*OverrideConfig, bool) {
+	if o, ok := cfg.Overrides[aws.ToString(user.UserName)]; ok && o.Match(user) {
+		return o, true
+	}
+	keys := make([]string, 0, len(cfg.Overrides))
+	for key := range cfg.Overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if o := cfg.Overrides[key]; o.Match(user) {
+			return o, true
+		}
+	}
+	return nil, false
+}
+
+// annotateOverrides sets the override and the reason to the changes caused by the overrides of the users.
+func (cfg *Config) annotateOverrides(changes []*Change, users []*User) {
+	overrides := make(map[string]*OverrideConfig)
+	for _, user := range users {
+		if o, ok := cfg.matchOverride(user); ok {
+			overrides[aws.ToString(user.UserName)] = o
+		}
+	}
+	for _, c := range changes {
+		o, ok := overrides[c.UserName]
+		if !ok {
+			continue
+		}
+		// the memberships removed by the override are not in the expected groups, so not annotated yet.
+		if c.Type == ChangeRemoveMembership && containsString(o.RemoveGroups, c.GroupName) {
+			c.Rule = o.String()
+		}
+		if c.Rule == o.String() {
+			c.Reason = o.Reason
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package qsgpm_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func newOverrideConfig(t *testing.T) string {
+	t.Helper()
+	base, err := filepath.Abs("testdata/config.yaml")
+	require.NoError(t, err)
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(`include: %q
+overrides:
+  tora:
+    add_groups:
+      - legacy
+    reason: legacy dashboards until migrated
+  HOGE@example.com:
+    remove_groups:
+      - authors
+    reason: manager only
+  Analyst/piyo@example.com:
+    custom_permission: manager
+    reason: acting manager
+`, base)), 0644))
+	return configPath
+}
+
+func TestConfigPlanSnapshotOverrides(t *testing.T) {
+	cfg := qsgpm.NewDefaultConfig()
+	require.NoError(t, cfg.Load(newOverrideConfig(t)))
+	snapshot, err := qsgpm.LoadSnapshot("testdata/snapshots/snapshot.json")
+	require.NoError(t, err)
+	plan, err := cfg.PlanSnapshot(snapshot)
+	require.NoError(t, err)
+	actual := make([]string, 0, len(plan.Changes))
+	for _, change := range plan.Changes {
+		actual = append(actual, change.String())
+	}
+	require.Equal(t, []string{
+		"[default] ~ update custom permission of Analyst/piyo@example.com: <nil> => manager (rule overrides[Analyst/piyo@example.com]: acting manager)",
+		"[default] ~ update custom permission of Manager/hoge@example.com: analysis => manager (rule rules[1])",
+		"[default] + create group analysts",
+		"[default] + create group managers",
+		"[default] + add Analyst/piyo@example.com to all (rule rules[2])",
+		"[default] + add Manager/hoge@example.com to all (rule rules[1])",
+		"[default] + add Analyst/piyo@example.com to analysts (rule rules[2])",
+		"[default] + add Analyst/piyo@example.com to authors (rule rules[2])",
+		"[default] + add Manager/hoge@example.com to managers (rule rules[1])",
+		"[default] - remove tora from all",
+		"[default] - remove Manager/hoge@example.com from authors (rule overrides[HOGE@example.com]: manager only)",
+	}, actual, "tora is kept in legacy by the override")
+}
+
+func TestAppRunAuditOverrides(t *testing.T) {
+	var buf bytes.Buffer
+	app, _ := newTestApp(t, newOverrideConfig(t), "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+		qsgpm.WithAuditSink(qsgpm.NewWriterAuditSink(&buf)),
+	)
+	defer app.Close()
+	require.NoError(t, app.Run(context.Background(), qsgpm.RunOption{}))
+	reasons := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var r qsgpm.AuditRecord
+		require.NoError(t, json.Unmarshal([]byte(line), &r))
+		if r.Reason != "" {
+			reasons[r.Rule] = r.Reason
+		}
+	}
+	require.Equal(t, map[string]string{
+		"overrides[Analyst/piyo@example.com]": "acting manager",
+		"overrides[HOGE@example.com]":         "manager only",
+	}, reasons)
+}
@@ -0,0 +1,389 @@
+package qsgpm
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// ChangeType is the type of a change to QuickSight.
+type ChangeType string
+
+const (
+	ChangeUpdateCustomPermission ChangeType = "update_custom_permission"
+	ChangeCreateGroup            ChangeType = "create_group"
+	ChangeAddMembership          ChangeType = "add_membership"
+	ChangeRemoveMembership       ChangeType = "remove_membership"
+	ChangeDeleteGroup            ChangeType = "delete_group"
+)
+
+// Change is a change to QuickSight, which is planned by the config and the current state.
+type Change struct {
+	Type      ChangeType `json:"type"`
+	Target    string     `json:"target,omitempty"`
+	Namespace string     `json:"namespace"`
+	GroupName string     `json:"group_name,omitempty"`
+	UserName  string     `json:"user_name,omitempty"`
+	// Email and Role are required for updating the custom permission of the user.
+	Email string `json:"email,omitempty"`
+	Role  string `json:"role,omitempty"`
+	// Before and After are the custom permission names. nil means no custom permission.
+	Before *string `json:"before,omitempty"`
+	After  *string `json:"after,omitempty"`
+	// Rule is the rule which caused the change, if any.
+	Rule string `json:"rule,omitempty"`
+	// Reason is the reason of the override which caused the change, if any.
+	Reason string `json:"reason,omitempty"`
+}
+
+func (c *Change) String() string {
+	var str string
+	switch c.Type {
+	case ChangeUpdateCustomPermission:
+		str = fmt.Sprintf("~ update custom permission of %s: %s => %s", c.UserName, viewStarString(c.Before), viewStarString(c.After))
+	case ChangeCreateGroup:
+		str = fmt.Sprintf("+ create group %s", c.GroupName)
+	case ChangeAddMembership:
+		str = fmt.Sprintf("+ add %s to %s", c.UserName, c.GroupName)
+	case ChangeRemoveMembership:
+		str = fmt.Sprintf("- remove %s from %s", c.UserName, c.GroupName)
+	case ChangeDeleteGroup:
+		str = fmt.Sprintf("- delete group %s", c.GroupName)
+	default:
+		str = fmt.Sprintf("? %s", c.Type)
+	}
+	if c.Reason != "" {
+		str += fmt.Sprintf(" (rule %s: %s)", c.Rule, c.Reason)
+	} else if c.Rule != "" {
+		str += fmt.Sprintf(" (rule %s)", c.Rule)
+	}
+	if c.Target != "" {
+		return fmt.Sprintf("[%s/%s] %s", c.Target, c.Namespace, str)
+	}
+	return fmt.Sprintf("[%s] %s", c.Namespace, str)
+}
+
+// Plan is the list of changes in the order to be applied.
+type Plan struct {
+	Changes []*Change `json:"changes"`
+	// Expirations are the memberships granted until the rules or the exceptions expire.
+	Expirations []*Expiration `json:"expirations,omitempty"`
+}
+
+func (p *Plan) IsEmpty() bool {
+	return len(p.Changes) == 0
+}
+
+// PlanSnapshot plans the changes of all targets from the snapshot as the current state, without accessing AWS.
+// Namespaces missing in the snapshot are planned as empty.
+func (cfg *Config) PlanSnapshot(snapshot *Snapshot) (*Plan, error) {
+	return cfg.planSnapshot(snapshot, planScope{})
+}
+
+// DriftSnapshot reports the drifts of the snapshot from the config.
+// Unlike PlanSnapshot, the memberships and the groups not in the config are reported even with create_only.
+func (cfg *Config) DriftSnapshot(snapshot *Snapshot) (*DriftReport, error) {
+	plan, err := cfg.planSnapshot(snapshot, planScope{allChanges: true})
+	if err != nil {
+		return nil, err
+	}
+	return NewDriftReport(plan), nil
+}
+
+func (cfg *Config) planSnapshot(snapshot *Snapshot, scope planScope) (*Plan, error) {
+	targetCfgs := cfg.Targets
+	if len(targetCfgs) == 0 {
+		targetCfgs = []*TargetConfig{{}}
+	}
+	plan := &Plan{
+		Changes: make([]*Change, 0),
+	}
+	for _, targetCfg := range targetCfgs {
+		ts, ok := snapshot.target(targetCfg, len(cfg.Targets) > 0)
+		if !ok {
+			return nil, fmt.Errorf("target %s: not found in the snapshot", targetCfg)
+		}
+		changes, err := cfg.planTarget(targetCfg, scope, plan.collectExpirations(cfg, targetCfg, func(namespace string) (*NamespaceState, error) {
+			if ns, ok := ts.namespace(namespace); ok {
+				return ns.State(), nil
+			}
+			return &NamespaceState{Groups: newGroups()}, nil
+		}), nil)
+		if err != nil {
+			return nil, err
+		}
+		plan.Changes = append(plan.Changes, changes...)
+	}
+	return plan, nil
+}
+
+// collectExpirations wraps getState to add the expirations of the users in the state to the plan.
+func (p *Plan) collectExpirations(cfg *Config, targetCfg *TargetConfig, getState func(namespace string) (*NamespaceState, error)) func(namespace string) (*NamespaceState, error) {
+	return func(namespace string) (*NamespaceState, error) {
+		state, err := getState(namespace)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range cfg.planExpirations(namespace, state.Users) {
+			e.Target = targetCfg.String()
+			p.Expirations = append(p.Expirations, e)
+		}
+		return state, nil
+	}
+}
+
+// planScope limits the namespaces and the users to plan. The zero value plans all.
+type planScope struct {
+	namespaces map[string]bool
+	users      map[string]bool
+	// allChanges plans the removals and the deletions even with create_only, to detect drift.
+	allChanges bool
+}
+
+func newPlanScope(opt RunOption) planScope {
+	var s planScope
+	if len(opt.Namespaces) > 0 {
+		s.namespaces = make(map[string]bool, len(opt.Namespaces))
+		for _, namespace := range opt.Namespaces {
+			s.namespaces[namespace] = true
+		}
+	}
+	if len(opt.Users) > 0 {
+		s.users = make(map[string]bool, len(opt.Users))
+		for _, user := range opt.Users {
+			s.users[user] = true
+		}
+	}
+	return s
+}
+
+// filterUsers returns the users in the scope.
+func (s planScope) filterUsers(users []*User) []*User {
+	if s.users == nil {
+		return users
+	}
+	filtered := make([]*User, 0, len(s.users))
+	for _, user := range users {
+		if s.users[aws.ToString(user.UserName)] {
+			filtered = append(filtered, user)
+		}
+	}
+	return filtered
+}
+
+// filterChanges returns the changes of the users in the scope.
+// Groups are created only for the memberships of the users, and never deleted.
+func (s planScope) filterChanges(changes []*Change) []*Change {
+	if s.users == nil {
+		return changes
+	}
+	groups := make(map[string]bool)
+	for _, c := range changes {
+		if c.Type == ChangeAddMembership && s.users[c.UserName] {
+			groups[c.GroupName] = true
+		}
+	}
+	filtered := make([]*Change, 0, len(changes))
+	for _, c := range changes {
+		switch c.Type {
+		case ChangeCreateGroup:
+			if groups[c.GroupName] {
+				filtered = append(filtered, c)
+			}
+		case ChangeDeleteGroup:
+		default:
+			if s.users[c.UserName] {
+				filtered = append(filtered, c)
+			}
+		}
+	}
+	return filtered
+}
+
+// planTarget plans the changes of the managed namespaces of the target in the scope, and counts the users if counts is not nil.
+func (cfg *Config) planTarget(targetCfg *TargetConfig, scope planScope, getState func(namespace string) (*NamespaceState, error), counts *SummaryCounts) ([]*Change, error) {
+	createOnly := cfg.CreateOnly
+	if targetCfg.CreateOnly != nil {
+		createOnly = *targetCfg.CreateOnly
+	}
+	if scope.allChanges {
+		createOnly = false
+	}
+	changes := make([]*Change, 0)
+	for _, namespace := range targetCfg.GetNamespaces(cfg.GetNamespaces()) {
+		if scope.namespaces != nil && !scope.namespaces[namespace] {
+			log.Printf("[debug] namespace %s is out of scope", namespace)
+			continue
+		}
+		log.Printf("[debug] namespace: %s", namespace)
+		state, err := getState(namespace)
+		if err != nil {
+			return nil, err
+		}
+		nsChanges, err := cfg.PlanNamespace(namespace, state, WithCreateOnly(createOnly))
+		if err != nil {
+			return nil, err
+		}
+		nsChanges = scope.filterChanges(nsChanges)
+		if counts != nil {
+			cfg.countUsers(scope.filterUsers(state.Users), counts)
+		}
+		for _, c := range nsChanges {
+			c.Target = targetCfg.String()
+		}
+		changes = append(changes, nsChanges...)
+	}
+	return changes, nil
+}
+
+// PlanNamespace plans the changes of a namespace from the current state.
+// The users and the groups ignored by the config are excluded from both the current and the expected groups.
+func (cfg *Config) PlanNamespace(namespace string, state *NamespaceState, optFns ...func(*ApplyGroupsOptions) error) ([]*Change, error) {
+	var opts ApplyGroupsOptions
+	for _, optFn := range optFns {
+		if err := optFn(&opts); err != nil {
+			return nil, err
+		}
+	}
+	changes := make([]*Change, 0)
+	expectGroups := newGroups()
+	opts.ignore = cfg.ignore
+	opts.ignoredUsers = make(map[string]bool)
+	opts.expiredGroups = make(map[string]map[string]bool)
+	overrides := make(map[string]*OverrideConfig)
+	for _, user := range state.Users {
+		if cfg.ignore.matchUser(user) {
+			log.Printf("[debug] user %s is ignored", *user.UserName)
+			opts.ignoredUsers[*user.UserName] = true
+			continue
+		}
+		if expired := cfg.expiredGroups(user); len(expired) > 0 {
+			opts.expiredGroups[*user.UserName] = expired
+		}
+		if rule, ok := cfg.MatchGroupRule(user); ok {
+			log.Printf("[debug] user %s matches rule %s for groups", *user.UserName, rule)
+			expectGroups.AssignRule(*user.UserName, rule)
+		}
+		for _, e := range cfg.activeExceptions(user) {
+			log.Printf("[debug] user %s has exception %s for groups until %s", *user.UserName, e, e.ExpiresAt)
+			expectGroups.assignException(*user.UserName, e)
+		}
+		override, overridden := cfg.matchOverride(user)
+		if overridden {
+			log.Printf("[debug] user %s is overridden by %s: %s", *user.UserName, override, override.Reason)
+			expectGroups.assignOverride(*user.UserName, override)
+			overrides[*user.UserName] = override
+		}
+		var customPermissionName *string
+		var customPermissionRule string
+		if overridden && override.CustomPermission != "" {
+			customPermissionName = aws.String(override.CustomPermission)
+			customPermissionRule = override.String()
+		} else if rule, ok := cfg.MatchCustomPermissionRule(user); ok {
+			log.Printf("[debug] user %s matches rule %s for custom permission", *user.UserName, rule)
+			customPermissionName = aws.String(rule.CustomPermission)
+			customPermissionRule = rule.String()
+		}
+		if !user.IsNeedUpdateCustomPermission(customPermissionName) {
+			continue
+		}
+		changes = append(changes, &Change{
+			Type:      ChangeUpdateCustomPermission,
+			Namespace: namespace,
+			UserName:  *user.UserName,
+			Email:     aws.ToString(user.Email),
+			Role:      string(user.Role),
+			Before:    user.CustomPermissionsName,
+			After:     customPermissionName,
+			Rule:      customPermissionRule,
+		})
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].UserName < changes[j].UserName
+	})
+	cfg.hierarchy.expand(expectGroups)
+	// removals are applied after the expansion, so that a parent group can be removed.
+	for user, o := range overrides {
+		expectGroups.unassignOverride(user, o)
+	}
+	changes = append(changes, planGroups(namespace, state.Groups, expectGroups, opts)...)
+	cfg.annotateOverrides(changes, state.Users)
+	return changes, nil
+}
+
+func (cfg *Config) countUsers(users []*User, counts *SummaryCounts) {
+	for _, user := range users {
+		if cfg.ignore.matchUser(user) {
+			continue
+		}
+		counts.UsersScanned++
+		_, groupsMatched := cfg.GetGroupNames(user)
+		customPermissionMatched := cfg.GetCustomPermissionName(user) != nil
+		if groupsMatched || customPermissionMatched {
+			counts.UsersMatched++
+		} else {
+			counts.UsersUnmatched++
+		}
+	}
+}
+
+// planGroups plans the changes of groups and memberships from the current groups to the expected groups.
+func planGroups(namespace string, nowGroups, expectGroups Groups, opts ApplyGroupsOptions) []*Change {
+	nowGroups, expectGroups = opts.ignore.apply(nowGroups, expectGroups, opts.ignoredUsers)
+	createGroups, _, deleteGroups := nowGroups.DiffGroup(expectGroups)
+	createMembership, _, deleteMembership := nowGroups.DiffMembership(expectGroups)
+	sort.Strings(createGroups)
+	sort.Strings(deleteGroups)
+	sortMembership(createMembership)
+	sortMembership(deleteMembership)
+
+	changes := make([]*Change, 0, len(createGroups)+len(createMembership)+len(deleteMembership)+len(deleteGroups))
+	for _, g := range createGroups {
+		changes = append(changes, &Change{
+			Type:      ChangeCreateGroup,
+			Namespace: namespace,
+			GroupName: g,
+		})
+	}
+	for _, gm := range createMembership {
+		changes = append(changes, &Change{
+			Type:      ChangeAddMembership,
+			Namespace: namespace,
+			GroupName: gm.GroupName,
+			UserName:  gm.UserName,
+			Rule:      gm.Rule,
+		})
+	}
+	for _, gm := range deleteMembership {
+		if opts.noDeleteGroupMembership && !opts.expiredGroups[gm.UserName][gm.GroupName] {
+			continue
+		}
+		changes = append(changes, &Change{
+			Type:      ChangeRemoveMembership,
+			Namespace: namespace,
+			GroupName: gm.GroupName,
+			UserName:  gm.UserName,
+		})
+	}
+	if !opts.noDeleteGroup {
+		for _, g := range deleteGroups {
+			changes = append(changes, &Change{
+				Type:      ChangeDeleteGroup,
+				Namespace: namespace,
+				GroupName: g,
+			})
+		}
+	}
+	return changes
+}
+
+func sortMembership(membership []Membership) {
+	sort.Slice(membership, func(i, j int) bool {
+		if membership[i].GroupName != membership[j].GroupName {
+			return membership[i].GroupName < membership[j].GroupName
+		}
+		return membership[i].UserName < membership[j].UserName
+	})
+}
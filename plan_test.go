@@ -0,0 +1,72 @@
+package qsgpm_test
+
+import (
+	"testing"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigPlanSnapshot(t *testing.T) {
+	snapshot, err := qsgpm.LoadSnapshot("testdata/snapshots/snapshot.json")
+	require.NoError(t, err)
+	cases := []struct {
+		createOnly bool
+		expected   []string
+	}{
+		{
+			createOnly: false,
+			expected: []string{
+				"[default] ~ update custom permission of Analyst/piyo@example.com: <nil> => analysis (rule rules[2])",
+				"[default] ~ update custom permission of Manager/hoge@example.com: analysis => manager (rule rules[1])",
+				"[default] + create group analysts",
+				"[default] + create group managers",
+				"[default] + add Analyst/piyo@example.com to all (rule rules[2])",
+				"[default] + add Manager/hoge@example.com to all (rule rules[1])",
+				"[default] + add Analyst/piyo@example.com to analysts (rule rules[2])",
+				"[default] + add Analyst/piyo@example.com to authors (rule rules[2])",
+				"[default] + add Manager/hoge@example.com to managers (rule rules[1])",
+				"[default] - remove tora from all",
+				"[default] - remove tora from legacy",
+				"[default] - delete group legacy",
+			},
+		},
+		{
+			createOnly: true,
+			expected: []string{
+				"[default] ~ update custom permission of Analyst/piyo@example.com: <nil> => analysis (rule rules[2])",
+				"[default] ~ update custom permission of Manager/hoge@example.com: analysis => manager (rule rules[1])",
+				"[default] + create group analysts",
+				"[default] + create group managers",
+				"[default] + add Analyst/piyo@example.com to all (rule rules[2])",
+				"[default] + add Manager/hoge@example.com to all (rule rules[1])",
+				"[default] + add Analyst/piyo@example.com to analysts (rule rules[2])",
+				"[default] + add Analyst/piyo@example.com to authors (rule rules[2])",
+				"[default] + add Manager/hoge@example.com to managers (rule rules[1])",
+			},
+		},
+	}
+	for _, c := range cases {
+		cfg := qsgpm.NewDefaultConfig()
+		err := cfg.Load("testdata/config.yaml")
+		require.NoError(t, err)
+		cfg.CreateOnly = c.createOnly
+		plan, err := cfg.PlanSnapshot(snapshot)
+		require.NoError(t, err)
+		actual := make([]string, 0, len(plan.Changes))
+		for _, change := range plan.Changes {
+			actual = append(actual, change.String())
+		}
+		require.Equal(t, c.expected, actual, "create_only: %v", c.createOnly)
+	}
+}
+
+func TestConfigPlanSnapshotTargetNotFound(t *testing.T) {
+	snapshot, err := qsgpm.LoadSnapshot("testdata/snapshots/snapshot.json")
+	require.NoError(t, err)
+	cfg := qsgpm.NewDefaultConfig()
+	err = cfg.Load("testdata/config_targets.yaml")
+	require.NoError(t, err)
+	_, err = cfg.PlanSnapshot(snapshot)
+	require.Error(t, err)
+}
@@ -2,53 +2,411 @@ package qsgpm
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"sync/atomic"
+	"time"
 )
 
 type App struct {
+	targets  []*target
+	cfg      *Config
+	metrics  *Metrics
+	auditor  *auditor
+	notifier *Notifier
+	readOnly bool
+}
+
+type target struct {
+	cfg *TargetConfig
 	svc *QuickSightService
-	cfg *Config
 }
 
-func New(ctx context.Context, cfg *Config) (*App, error) {
-	svc, err := newQuickSightService(ctx)
-	if err != nil {
-		return nil, err
+type AppOptions struct {
+	aws                 *AWSConfig
+	logChange           ChangeEventHandler
+	changeEventHandlers []ChangeEventHandler
+	client              QuickSightClient
+	awsAccountID        string
+	metrics             *Metrics
+	auditSinks          []AuditSink
+	readOnly            bool
+}
+
+// WithAWSConfig overrides the aws section of the config, e.g. by commandline flags.
+// The merged config is validated, so the external ID may be given for the role in the config.
+func WithAWSConfig(awsCfg *AWSConfig) func(*AppOptions) error {
+	return func(opt *AppOptions) error {
+		merged := awsCfg.Merge(opt.aws)
+		if err := merged.Restrict(); err != nil {
+			return fmt.Errorf("aws: %w", err)
+		}
+		opt.aws = merged
+		return nil
+	}
+}
+
+// WithQuickSightClient uses the client for all targets instead of the client by the AWS config, e.g. for testing.
+// The account ID of a target is used if specified.
+func WithQuickSightClient(client QuickSightClient, awsAccountID string) func(*AppOptions) error {
+	return func(opt *AppOptions) error {
+		opt.client = client
+		opt.awsAccountID = awsAccountID
+		return nil
+	}
+}
+
+// WithMetrics collects the metrics of the runs into m.
+func WithMetrics(m *Metrics) func(*AppOptions) error {
+	return func(opt *AppOptions) error {
+		opt.metrics = m
+		return nil
+	}
+}
+
+// WithAuditSink adds a destination of the audit records, in addition to the sinks of the audit section.
+// The sink is closed by App.Close.
+func WithAuditSink(sink AuditSink) func(*AppOptions) error {
+	return func(opt *AppOptions) error {
+		opt.auditSinks = append(opt.auditSinks, sink)
+		return nil
+	}
+}
+
+// WithReadOnly creates the app which never changes QuickSight, e.g. for plan and export.
+// The audit sinks are not opened and the caller identity is not resolved, and only dry runs are allowed.
+func WithReadOnly() func(*AppOptions) error {
+	return func(opt *AppOptions) error {
+		opt.readOnly = true
+		return nil
+	}
+}
+
+// WithChangeLogger replaces the log of the applied changes, LogChangeEvent by default.
+func WithChangeLogger(h ChangeEventHandler) func(*AppOptions) error {
+	return func(opt *AppOptions) error {
+		opt.logChange = h
+		return nil
+	}
+}
+
+// WithChangeEventHandler adds a handler of the change events, which is called for each change applied to QuickSight.
+func WithChangeEventHandler(h ChangeEventHandler) func(*AppOptions) error {
+	return func(opt *AppOptions) error {
+		opt.changeEventHandlers = append(opt.changeEventHandlers, h)
+		return nil
+	}
+}
+
+func New(ctx context.Context, cfg *Config, optFns ...func(*AppOptions) error) (*App, error) {
+	opts := AppOptions{
+		aws: cfg.AWS,
+	}
+	if opts.aws == nil {
+		opts.aws = &AWSConfig{}
+	}
+	for _, optFn := range optFns {
+		if err := optFn(&opts); err != nil {
+			return nil, err
+		}
+	}
+	var audit *auditor
+	if !opts.readOnly {
+		var err error
+		audit, err = newAuditor(cfg, opts.auditSinks)
+		if err != nil {
+			return nil, err
+		}
+	}
+	targetCfgs := cfg.Targets
+	if len(targetCfgs) == 0 {
+		targetCfgs = []*TargetConfig{{}}
+	}
+	targets := make([]*target, 0, len(targetCfgs))
+	for _, targetCfg := range targetCfgs {
+		var svc *QuickSightService
+		var err error
+		if opts.client != nil {
+			svc = &QuickSightService{
+				awsAccountID: coalesceString(targetCfg.AccountID, opts.awsAccountID),
+				client:       opts.client,
+			}
+		} else {
+			svc, err = newQuickSightService(ctx, opts.aws, targetCfg, audit != nil)
+		}
+		if err != nil {
+			if audit != nil {
+				audit.Close()
+			}
+			if len(cfg.Targets) == 0 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("target %s: %w", targetCfg, err)
+		}
+		svc.logChange = opts.logChange
+		svc.changeEventHandlers = opts.changeEventHandlers
+		svc.auditor = audit
+		targets = append(targets, &target{
+			cfg: targetCfg,
+			svc: svc,
+		})
 	}
 	return &App{
-		svc: svc,
-		cfg: cfg,
+		targets:  targets,
+		cfg:      cfg,
+		metrics:  opts.metrics,
+		auditor:  audit,
+		notifier: NewNotifier(cfg.Notifications),
+		readOnly: opts.readOnly,
 	}, nil
 }
 
+// newAuditor opens the sinks of the audit section, and returns nil if no sinks.
+func newAuditor(cfg *Config, sinks []AuditSink) (*auditor, error) {
+	auditCfg := cfg.Audit
+	if auditCfg == nil {
+		auditCfg = &AuditConfig{}
+	}
+	a := &auditor{
+		sinks:       append([]AuditSink{}, sinks...),
+		actor:       auditCfg.Actor,
+		configHash:  cfg.Hash(),
+		gitRevision: auditCfg.GitRevision,
+	}
+	for _, sinkCfg := range auditCfg.Sinks {
+		sink, err := sinkCfg.NewSink()
+		if err != nil {
+			a.Close()
+			return nil, err
+		}
+		a.sinks = append(a.sinks, sink)
+	}
+	if len(a.sinks) == 0 {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// Close closes the audit sinks.
+func (app *App) Close() error {
+	if app.auditor == nil {
+		return nil
+	}
+	return app.auditor.Close()
+}
+
 type RunOption struct {
 	DryRun bool
+	// Namespaces limits the namespaces to reconcile, all namespaces of the config if empty.
+	Namespaces []string
+	// Users limits the users to reconcile by the user names, all users if empty.
+	// Only the memberships and the custom permissions of the users are changed, and groups are never deleted.
+	Users []string
+	// RequestedBy is who requests the run, recorded in the pending plan if it needs an approval.
+	RequestedBy string
+
+	// gate checks the plan of all targets before applied, the thresholds of the approval section by default.
+	gate func(*Plan) error
 }
 
 func (app *App) Run(ctx context.Context, opt RunOption) error {
-	svc := app.svc
-	if opt.DryRun {
-		svc = svc.GetDryRunService()
-	}
-	namespaces := app.cfg.GetNamespaces()
-	for _, namespace := range namespaces {
-		log.Printf("[debug] namespace: %s", namespace)
-		expectGroups := newGroups()
-		p := svc.NewUsersPaginator(namespace)
-		for p.HasMoreUsers() {
-			users, err := p.NextUsers(ctx)
-			if err != nil {
-				return err
+	_, err := app.RunWithSummary(ctx, opt)
+	return err
+}
+
+// RunWithSummary runs as Run, and returns the summary of the run.
+func (app *App) RunWithSummary(ctx context.Context, opt RunOption) (summary *RunSummary, err error) {
+	if app.readOnly && !opt.DryRun {
+		return nil, errors.New("the read-only app can not apply changes, only dry run")
+	}
+	summary = &RunSummary{
+		StartedAt: time.Now(),
+		DryRun:    opt.DryRun,
+		Targets:   make([]*TargetSummary, 0, len(app.targets)),
+	}
+	defer func() {
+		summary.Elapsed = time.Since(summary.StartedAt)
+		log.Printf("[info] summary: %s", summary)
+		if app.metrics != nil {
+			app.metrics.observeRun(summary, err)
+		}
+		changes := make([]*ChangeEvent, 0)
+		for _, ts := range summary.Targets {
+			changes = append(changes, ts.changes...)
+		}
+		// dry runs apply nothing, so only their failures are notified.
+		if opt.DryRun && err == nil {
+			return
+		}
+		if nerr := app.notifier.Notify(ctx, NewRunNotification(summary, changes, err)); nerr != nil {
+			log.Printf("[warn] %s", nerr)
+		}
+	}()
+	runs := make([]*targetRun, 0, len(app.targets))
+	for _, t := range app.targets {
+		if len(app.cfg.Targets) > 0 {
+			log.Printf("[info] target %s (account %s)", t.cfg, t.svc.awsAccountID)
+		}
+		runs = append(runs, app.planTargetRun(ctx, t, opt))
+	}
+	gate := opt.gate
+	if gate == nil {
+		gate = app.approvalGate(opt)
+	}
+	if gate != nil {
+		plan := &Plan{
+			Changes: make([]*Change, 0),
+		}
+		for _, r := range runs {
+			plan.Changes = append(plan.Changes, r.changes...)
+		}
+		if err := gate(plan); err != nil {
+			for _, r := range runs {
+				r.finish(r.err)
+				summary.Targets = append(summary.Targets, r.summary)
+				summary.add(r.summary.SummaryCounts)
 			}
-			for _, user := range users {
-				if groupNames, ok := app.cfg.GetGroupNames(user); ok {
-					expectGroups.Assign(*user.UserName, groupNames)
-				}
-				customPermissionName := app.cfg.GetCustomPermissionName(user)
-				svc.UpdateUserCustomPermission(ctx, user, customPermissionName)
+			return summary, err
+		}
+	}
+	if len(app.cfg.Targets) == 0 {
+		r := runs[0]
+		r.apply(ctx)
+		summary.Targets = append(summary.Targets, r.summary)
+		summary.add(r.summary.SummaryCounts)
+		return summary, r.err
+	}
+	errs := make([]error, 0)
+	results := make([]string, 0, len(runs))
+	for _, r := range runs {
+		r.apply(ctx)
+		summary.Targets = append(summary.Targets, r.summary)
+		summary.add(r.summary.SummaryCounts)
+		if r.err != nil {
+			log.Printf("[error] target %s: %s", r.t.cfg, r.err)
+			errs = append(errs, fmt.Errorf("target %s: %w", r.t.cfg, r.err))
+			results = append(results, fmt.Sprintf("%s: failed", r.t.cfg))
+			continue
+		}
+		results = append(results, fmt.Sprintf("%s: succeeded", r.t.cfg))
+	}
+	for _, result := range results {
+		log.Printf("[info] result of target %s", result)
+	}
+	return summary, errors.Join(errs...)
+}
+
+// targetRun is a run of a target, whose changes are planned for all targets before applied.
+type targetRun struct {
+	t       *target
+	svc     QuickSightService
+	summary *TargetSummary
+	calls   *int64
+	changes []*Change
+	err     error
+}
+
+// planTargetRun plans the changes of the target, and prepares the service to apply them.
+func (app *App) planTargetRun(ctx context.Context, t *target, opt RunOption) *targetRun {
+	ts := &TargetSummary{
+		Name:      t.cfg.String(),
+		AccountID: t.svc.awsAccountID,
+	}
+	calls := new(int64)
+	svc := *t.svc
+	svc.client = observedClient{
+		QuickSightClient: svc.client,
+		observe: func(operation string, latency time.Duration, err error) {
+			atomic.AddInt64(calls, 1)
+			if app.metrics != nil {
+				app.metrics.observeAPI(operation, latency, err)
+			}
+		},
+	}
+	svc.changeEventHandlers = append(append([]ChangeEventHandler{}, svc.changeEventHandlers...), ts.countChange, func(e *ChangeEvent) {
+		ts.changes = append(ts.changes, e)
+	})
+	if app.metrics != nil {
+		svc.changeEventHandlers = append(svc.changeEventHandlers, app.metrics.observeChange)
+	}
+	if opt.DryRun {
+		svc = *svc.GetDryRunService()
+	}
+	r := &targetRun{
+		t:       t,
+		svc:     svc,
+		summary: ts,
+		calls:   calls,
+	}
+	r.changes, r.err = app.cfg.planTarget(t.cfg, newPlanScope(opt), func(namespace string) (*NamespaceState, error) {
+		if len(opt.Users) > 0 {
+			return svc.GetUsersState(ctx, namespace, opt.Users, app.cfg.expectGroupNames)
+		}
+		return svc.GetNamespaceState(ctx, namespace)
+	}, &ts.SummaryCounts)
+	return r
+}
+
+// apply applies the planned changes, unless the planning failed.
+func (r *targetRun) apply(ctx context.Context) {
+	if r.err == nil {
+		r.finish(r.svc.ApplyChanges(ctx, r.changes))
+		return
+	}
+	r.finish(r.err)
+}
+
+// finish records the result of the run into the summary.
+func (r *targetRun) finish(err error) {
+	r.err = err
+	r.summary.APICalls = int(atomic.LoadInt64(r.calls))
+	if err != nil {
+		r.summary.Errors++
+		r.summary.Error = err.Error()
+	}
+}
+
+// ReconcileUsers reconciles only the named users, reading only the users and the groups relevant to them.
+// It is faster than Run on large accounts, and never deletes groups.
+func (app *App) ReconcileUsers(ctx context.Context, userNames []string, opt RunOption) (*RunSummary, error) {
+	if len(userNames) == 0 {
+		return nil, errors.New("no users to reconcile")
+	}
+	opt.Users = userNames
+	return app.RunWithSummary(ctx, opt)
+}
+
+// Plan plans the changes of all targets from the current state, without applying them.
+func (app *App) Plan(ctx context.Context) (*Plan, error) {
+	return app.plan(ctx, planScope{})
+}
+
+// Drift reports the drifts of the current state from the config.
+// Unlike Plan, the memberships and the groups not in the config are reported even with create_only.
+func (app *App) Drift(ctx context.Context) (*DriftReport, error) {
+	plan, err := app.plan(ctx, planScope{allChanges: true})
+	if err != nil {
+		return nil, err
+	}
+	return NewDriftReport(plan), nil
+}
+
+func (app *App) plan(ctx context.Context, scope planScope) (*Plan, error) {
+	plan := &Plan{
+		Changes: make([]*Change, 0),
+	}
+	for _, t := range app.targets {
+		changes, err := app.cfg.planTarget(t.cfg, scope, plan.collectExpirations(app.cfg, t.cfg, func(namespace string) (*NamespaceState, error) {
+			return t.svc.GetNamespaceState(ctx, namespace)
+		}), nil)
+		if err != nil {
+			if len(app.cfg.Targets) == 0 {
+				return nil, err
 			}
+			return nil, fmt.Errorf("target %s: %w", t.cfg, err)
 		}
-		svc.ApplyGroups(ctx, namespace, expectGroups, WithCreateOnly(app.cfg.CreateOnly))
+		plan.Changes = append(plan.Changes, changes...)
 	}
-	return nil
+	return plan, nil
 }
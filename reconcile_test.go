@@ -0,0 +1,42 @@
+package qsgpm_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppReconcileUsers(t *testing.T) {
+	app, fake := newTestApp(t, "testdata/config.yaml", "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+	)
+	ctx := context.Background()
+	_, err := app.ReconcileUsers(ctx, nil, qsgpm.RunOption{})
+	require.EqualError(t, err, "no users to reconcile")
+
+	summary, err := app.ReconcileUsers(ctx, []string{"Analyst/piyo@example.com", "tora", "nobody"}, qsgpm.RunOption{})
+	require.NoError(t, err)
+	require.Equal(t, 2, summary.UsersScanned)
+	require.Equal(t, 1, summary.UsersMatched)
+	require.Equal(t, 3, summary.MembershipsAdded)
+	require.Equal(t, 2, summary.MembershipsRemoved)
+	require.Equal(t, 1, summary.GroupsCreated)
+	require.Equal(t, 0, summary.GroupsDeleted)
+	// DescribeUser for 3 users, ListUserGroups for 2 users, DescribeGroup for 3 groups of piyo, and 7 changes
+	require.Equal(t, 15, summary.APICalls)
+	require.Equal(t, []string{
+		"UpdateUser",
+		"CreateGroup",
+		"CreateGroupMembership",
+		"CreateGroupMembership",
+		"CreateGroupMembership",
+		"DeleteGroupMembership",
+		"DeleteGroupMembership",
+	}, fake.calls)
+
+	summary, err = app.ReconcileUsers(ctx, []string{"Analyst/piyo@example.com", "tora"}, qsgpm.RunOption{})
+	require.NoError(t, err)
+	require.Equal(t, qsgpm.SummaryCounts{UsersScanned: 2, UsersMatched: 1, UsersUnmatched: 1, APICalls: 4}, summary.SummaryCounts, "converged, and no groups to describe")
+}
@@ -0,0 +1,159 @@
+package qsgpm
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+//go:generate sh -c "go run ./cmd/qsgpm schema > schema.json"
+
+const schemaID = "https://raw.githubusercontent.com/mashiike/qsgpm/main/schema.json"
+
+// Schema is a subset of JSON Schema, used for describing the config.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	ID                   string             `json:"$id,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+
+	// closed means that additionalProperties is false.
+	closed bool
+}
+
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	type schema Schema
+	if !s.closed {
+		return json.Marshal((*schema)(s))
+	}
+	return json.Marshal(struct {
+		*schema
+		AdditionalProperties bool `json:"additionalProperties"`
+	}{
+		schema: (*schema)(s),
+	})
+}
+
+// schemaDescriptions are the descriptions of the config keys, keyed by the struct name and the key.
+var schemaDescriptions = map[string]string{
+	"Config.required_version":                      "version constraints of qsgpm, such as \">=0.3.0\"",
+	"Config.create_only":                           "if true, groups and group memberships are never deleted",
+	"Config.namespaces":                            "rule sets per namespace",
+	"Config.targets":                               "AWS accounts and regions to which the config is applied",
+	"UserConfig.identity_type":                     "one of IAM or QUICKSIGHT (case-insensitive)",
+	"UserConfig.role":                              "one of ADMIN, AUTHOR, READER, RESTRICTED_AUTHOR or RESTRICTED_READER (case-insensitive)",
+	"UserConfig.session_name_suffix":               "suffix of the session name of IAM users",
+	"UserConfig.iam_role_name":                     "IAM role name of IAM users",
+	"RuleConfig.id":                                "unique id of the rule, used in reports and as the merge key of config layering",
+	"TargetConfig.account_id":                      "12-digit AWS account ID; if omitted, resolved by STS GetCallerIdentity",
+	"TargetConfig.create_only":                     "overrides the top-level create_only",
+	"TargetConfig.namespaces":                      "namespaces managed in the target",
+	"Config.audit":                                 "audit log of the changes applied to QuickSight",
+	"AuditConfig.actor":                            "who runs qsgpm, recorded in the audit records",
+	"AuditConfig.git_revision":                     "git revision of the config, recorded in the audit records",
+	"AuditSinkConfig.type":                         "file or stdout",
+	"AuditSinkConfig.path":                         "file path to append the audit records as JSON lines, for the file sink",
+	"Config.notifications":                         "webhooks notified of the applied changes, the detected drift or the failures",
+	"NotificationConfig.preset":                    "payload format, one of generic, slack or teams (default: generic)",
+	"NotificationConfig.events":                    "events to notify, any of changes, drift or failure (default: all)",
+	"NotificationConfig.template":                  "text of the notification, in which ${title}, ${details}, ${summary}, ${count}, ${error}, ${event} and ${dry_run} are expanded",
+	"NotificationConfig.min_changes":               "notifies the changes only if at least this number of changes are applied (default: 1)",
+	"NotificationConfig.min_drifts":                "notifies the drift only if at least this number of drifts are detected (default: 1)",
+	"Config.approval":                              "thresholds of the plans which need an approval by qsgpm approve before applied",
+	"ApprovalConfig.dir":                           "directory of the pending plans (default: .qsgpm/pending)",
+	"ApprovalConfig.max_changes":                   "maximum number of changes applied without an approval",
+	"ApprovalConfig.max_membership_removals":       "maximum number of membership removals applied without an approval",
+	"ApprovalConfig.max_group_deletions":           "maximum number of group deletions applied without an approval",
+	"ApprovalConfig.max_custom_permission_changes": "maximum number of custom permission changes applied without an approval",
+	"RuleConfig.expires_at":                        "RFC 3339 time or date after which the rule no longer matches",
+	"Config.exceptions":                            "groups granted to a user until they expire, in addition to the rules",
+	"ExceptionConfig.user_name":                    "user name of the user",
+	"ExceptionConfig.email":                        "email of the user, case insensitive",
+	"ExceptionConfig.namespace":                    "namespace of the user, all namespaces if empty",
+	"ExceptionConfig.expires_at":                   "RFC 3339 time or date after which the groups are removed",
+	"ExceptionConfig.reason":                       "why the exception is granted, shown in the plan",
+	"Config.overrides":                             "groups added or removed and custom permissions pinned per user, keyed by the user name or the email",
+	"OverrideConfig.namespace":                     "namespace of the user, all namespaces if empty",
+	"OverrideConfig.add_groups":                    "groups added to the groups of the rules",
+	"OverrideConfig.remove_groups":                 "groups removed from the groups of the rules",
+	"OverrideConfig.custom_permission":             "custom permission pinned regardless of the rules",
+	"OverrideConfig.reason":                        "why the override is needed, shown in the plan and the audit log",
+	"Config.ignore_users":                          "user names or emails never touched by qsgpm, exact names or patterns with * and ?",
+	"Config.ignore_groups":                         "groups never touched by qsgpm, exact names or patterns with * and ?",
+	"Config.group_hierarchy":                       "child groups of the parent groups, the members of a child group are also members of the parent groups",
+	"AWSConfig.account_id":                         "12-digit AWS account ID; if specified, STS GetCallerIdentity is skipped",
+	"AWSConfig.endpoint":                           "QuickSight API endpoint URL",
+	"Config." + layerKeyInclude:                    "config files merged under this file, relative to this file",
+	"Config." + layerKeyExtends:                    "alias of include",
+	"Config." + layerKeyRulesMerge:                 "strategy for merging rules into the preceding config files",
+}
+
+// GenerateSchema returns the JSON Schema of the config file.
+func GenerateSchema() *Schema {
+	s := schemaOf(reflect.TypeOf(Config{}))
+	s.Schema = "https://json-schema.org/draft/2020-12/schema"
+	s.ID = schemaID
+	s.Title = "qsgpm config"
+	stringOrList := func(key string) *Schema {
+		return &Schema{
+			Description: schemaDescriptions["Config."+key],
+			OneOf: []*Schema{
+				{Type: "string"},
+				{Type: "array", Items: &Schema{Type: "string"}},
+			},
+		}
+	}
+	s.Properties[layerKeyInclude] = stringOrList(layerKeyInclude)
+	s.Properties[layerKeyExtends] = stringOrList(layerKeyExtends)
+	s.Properties[layerKeyRulesMerge] = &Schema{
+		Type:        "string",
+		Description: schemaDescriptions["Config."+layerKeyRulesMerge],
+		Enum:        []string{string(RulesMergeAppend), string(RulesMergePrepend), string(RulesMergeReplace)},
+	}
+	return s
+}
+
+func schemaOf(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	s := &Schema{}
+	switch t.Kind() {
+	case reflect.Struct:
+		s.Type = "object"
+		s.Properties = make(map[string]*Schema, t.NumField())
+		s.closed = true
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			if key == "" || key == "-" {
+				continue
+			}
+			p := schemaOf(f.Type)
+			p.Description = schemaDescriptions[t.Name()+"."+key]
+			s.Properties[key] = p
+		}
+	case reflect.Slice:
+		s.Type = "array"
+		s.Items = schemaOf(t.Elem())
+	case reflect.Map:
+		s.Type = "object"
+		s.AdditionalProperties = schemaOf(t.Elem())
+	case reflect.Bool:
+		s.Type = "boolean"
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		s.Type = "integer"
+	default:
+		s.Type = "string"
+	}
+	return s
+}
@@ -0,0 +1,337 @@
+package qsgpm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ServerOptions are the options of Server.
+type ServerOptions struct {
+	interval       time.Duration
+	jitter         time.Duration
+	reloadInterval time.Duration
+	dryRun         bool
+}
+
+// WithInterval sets the interval of the periodic runs, 15 minutes by default.
+func WithInterval(d time.Duration) func(*ServerOptions) error {
+	return func(opt *ServerOptions) error {
+		if d <= 0 {
+			return fmt.Errorf("interval %s must be positive", d)
+		}
+		opt.interval = d
+		return nil
+	}
+}
+
+// WithJitter sets the maximum random delay added to each interval, 10% of the interval by default.
+func WithJitter(d time.Duration) func(*ServerOptions) error {
+	return func(opt *ServerOptions) error {
+		if d < 0 {
+			return fmt.Errorf("jitter %s must not be negative", d)
+		}
+		opt.jitter = d
+		return nil
+	}
+}
+
+// WithReloadInterval sets the interval of checking the config changes, 30 seconds by default.
+func WithReloadInterval(d time.Duration) func(*ServerOptions) error {
+	return func(opt *ServerOptions) error {
+		if d <= 0 {
+			return fmt.Errorf("reload interval %s must be positive", d)
+		}
+		opt.reloadInterval = d
+		return nil
+	}
+}
+
+// WithScheduledDryRun makes the periodic runs dry runs.
+func WithScheduledDryRun(dryRun bool) func(*ServerOptions) error {
+	return func(opt *ServerOptions) error {
+		opt.dryRun = dryRun
+		return nil
+	}
+}
+
+// RunResult is the result of a run by Server.
+type RunResult struct {
+	FinishedAt time.Time   `json:"finished_at"`
+	Summary    *RunSummary `json:"summary"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// ConfigLoader loads the config, called at start and on each reload check.
+// It should keep the ConfigSource to revalidate the cache on reloads.
+type ConfigLoader func(ctx context.Context) (*Config, error)
+
+// AppFactory creates the App for the config with the options given by Server.
+type AppFactory func(ctx context.Context, cfg *Config, optFns ...func(*AppOptions) error) (*App, error)
+
+// Server runs the reconciliation periodically, reloads the config on change,
+// and serves the status and on-demand runs over HTTP.
+type Server struct {
+	opts    ServerOptions
+	load    ConfigLoader
+	newApp  AppFactory
+	metrics *Metrics
+
+	// runMu serializes the runs and the reloads.
+	runMu sync.Mutex
+
+	mu      sync.Mutex
+	app     *App
+	lastRun *RunResult
+}
+
+// NewServer loads the config and creates the App.
+func NewServer(ctx context.Context, load ConfigLoader, newApp AppFactory, optFns ...func(*ServerOptions) error) (*Server, error) {
+	opts := ServerOptions{
+		interval:       15 * time.Minute,
+		jitter:         -1,
+		reloadInterval: 30 * time.Second,
+	}
+	for _, optFn := range optFns {
+		if err := optFn(&opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.jitter < 0 {
+		opts.jitter = opts.interval / 10
+	}
+	s := &Server{
+		opts:    opts,
+		load:    load,
+		newApp:  newApp,
+		metrics: NewMetrics(),
+	}
+	cfg, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	app, err := newApp(ctx, cfg, WithMetrics(s.metrics))
+	if err != nil {
+		return nil, err
+	}
+	s.app = app
+	return s, nil
+}
+
+// Reload loads the config, and swaps the App if the config is changed.
+// If the new config is invalid, the last good one is kept.
+func (s *Server) Reload(ctx context.Context) (bool, error) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	return s.reload(ctx)
+}
+
+func (s *Server) reload(ctx context.Context) (bool, error) {
+	cfg, err := s.load(ctx)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	current := s.app
+	s.mu.Unlock()
+	if cfg.Hash() == current.cfg.Hash() {
+		return false, nil
+	}
+	app, err := s.newApp(ctx, cfg, WithMetrics(s.metrics))
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	s.app = app
+	s.mu.Unlock()
+	if err := current.Close(); err != nil {
+		log.Printf("[warn] failed to close the previous app: %s", err)
+	}
+	log.Printf("[info] config reloaded: %s", cfg.Hash())
+	return true, nil
+}
+
+// RunOnce runs the reconciliation, waiting for the running one if any.
+func (s *Server) RunOnce(ctx context.Context, opt RunOption) *RunResult {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	return s.run(ctx, opt)
+}
+
+func (s *Server) run(ctx context.Context, opt RunOption) *RunResult {
+	if opt.RequestedBy == "" {
+		opt.RequestedBy = "qsgpm serve"
+	}
+	s.mu.Lock()
+	app := s.app
+	s.mu.Unlock()
+	summary, err := app.RunWithSummary(ctx, opt)
+	result := &RunResult{
+		FinishedAt: time.Now(),
+		Summary:    summary,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	s.mu.Lock()
+	s.lastRun = result
+	s.mu.Unlock()
+	return result
+}
+
+// Run runs the reconciliation at start and every interval with jitter, and checks the config changes, until ctx is done.
+func (s *Server) Run(ctx context.Context) error {
+	next := time.NewTimer(0)
+	defer next.Stop()
+	reload := time.NewTicker(s.opts.reloadInterval)
+	defer reload.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-next.C:
+			if result := s.RunOnce(ctx, RunOption{DryRun: s.opts.dryRun}); result.Error != "" {
+				log.Printf("[error] run failed: %s", result.Error)
+			}
+			wait := s.opts.interval
+			if s.opts.jitter > 0 {
+				wait += time.Duration(rand.Int63n(int64(s.opts.jitter) + 1))
+			}
+			log.Printf("[info] next run in %s", wait.Round(time.Second))
+			next.Reset(wait)
+		case <-reload.C:
+			// skip the check while running, and check it at the next tick.
+			if !s.runMu.TryLock() {
+				continue
+			}
+			if _, err := s.reload(ctx); err != nil {
+				log.Printf("[error] failed to reload the config, keep the current config: %s", err)
+			}
+			s.runMu.Unlock()
+		}
+	}
+}
+
+// Metrics returns the metrics of the runs.
+func (s *Server) Metrics() *Metrics {
+	return s.metrics
+}
+
+// Close closes the App.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.app.Close()
+}
+
+// Handler serves the endpoints:
+//
+//   - GET /healthz: 200 while serving, with the result of the last run
+//   - GET /metrics: the metrics in the Prometheus text format
+//   - GET /last-run: the result of the last run, 404 if no runs yet
+//   - POST /run: runs now, with the query dry_run=true, user=<user name> and namespace=<namespace>; 409 if running
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/last-run", s.handleLastRun)
+	mux.HandleFunc("/run", s.handleRun)
+	return mux
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeHTTPError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	s.mu.Lock()
+	status := struct {
+		Status     string     `json:"status"`
+		ConfigHash string     `json:"config_hash,omitempty"`
+		LastRun    *RunResult `json:"last_run,omitempty"`
+	}{
+		Status:     "ok",
+		ConfigHash: s.app.cfg.Hash(),
+		LastRun:    s.lastRun,
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeHTTPError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := s.metrics.WritePrometheus(w); err != nil {
+		log.Printf("[warn] failed to write metrics: %s", err)
+	}
+}
+
+func (s *Server) handleLastRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeHTTPError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	s.mu.Lock()
+	result := s.lastRun
+	s.mu.Unlock()
+	if result == nil {
+		writeHTTPError(w, http.StatusNotFound, errors.New("no runs yet"))
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeHTTPError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	query := r.URL.Query()
+	opt := RunOption{
+		Namespaces: query["namespace"],
+		Users:      query["user"],
+	}
+	if v := query.Get("dry_run"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("dry_run: %w", err))
+			return
+		}
+		opt.DryRun = dryRun
+	}
+	if !s.runMu.TryLock() {
+		writeHTTPError(w, http.StatusConflict, errors.New("a run is in progress"))
+		return
+	}
+	defer s.runMu.Unlock()
+	result := s.run(r.Context(), opt)
+	if result.Error != "" {
+		writeJSON(w, http.StatusInternalServerError, result)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("[warn] failed to write the response: %s", err)
+	}
+}
+
+func writeHTTPError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,125 @@
+package qsgpm_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T, configPath string) (*qsgpm.Server, *fakeQuickSight) {
+	t.Helper()
+	snapshot, err := qsgpm.LoadSnapshot("testdata/snapshots/snapshot.json")
+	require.NoError(t, err)
+	fake := newFakeQuickSight(snapshot)
+	s, err := qsgpm.NewServer(
+		context.Background(),
+		func(ctx context.Context) (*qsgpm.Config, error) {
+			cfg := qsgpm.NewDefaultConfig()
+			if err := cfg.Load(configPath); err != nil {
+				return nil, err
+			}
+			return cfg, nil
+		},
+		func(ctx context.Context, cfg *qsgpm.Config, optFns ...func(*qsgpm.AppOptions) error) (*qsgpm.App, error) {
+			optFns = append(optFns,
+				qsgpm.WithQuickSightClient(fake, "123456789012"),
+				qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+			)
+			return qsgpm.New(ctx, cfg, optFns...)
+		},
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s, fake
+}
+
+func doRequest(t *testing.T, h http.Handler, method, target string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	bs, err := io.ReadAll(rec.Result().Body)
+	require.NoError(t, err)
+	return rec.Code, string(bs)
+}
+
+func TestServerHandler(t *testing.T) {
+	s, fake := newTestServer(t, "testdata/config.yaml")
+	h := s.Handler()
+
+	code, _ := doRequest(t, h, http.MethodGet, "/last-run")
+	require.Equal(t, http.StatusNotFound, code)
+	code, _ = doRequest(t, h, http.MethodGet, "/run")
+	require.Equal(t, http.StatusMethodNotAllowed, code)
+	code, _ = doRequest(t, h, http.MethodPost, "/run?dry_run=maybe")
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, body := doRequest(t, h, http.MethodPost, "/run?dry_run=true")
+	require.Equal(t, http.StatusOK, code, body)
+	var result qsgpm.RunResult
+	require.NoError(t, json.Unmarshal([]byte(body), &result))
+	require.True(t, result.Summary.DryRun)
+	require.Equal(t, 5, result.Summary.MembershipsAdded)
+	for _, call := range fake.calls {
+		require.False(t, strings.HasPrefix(call, "Create"), "dry run calls %s", call)
+	}
+
+	code, body = doRequest(t, h, http.MethodPost, "/run?user=tora")
+	require.Equal(t, http.StatusOK, code, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &result))
+	require.False(t, result.Summary.DryRun)
+	require.Equal(t, 2, result.Summary.MembershipsRemoved)
+
+	code, body = doRequest(t, h, http.MethodGet, "/last-run")
+	require.Equal(t, http.StatusOK, code)
+	var lastRun qsgpm.RunResult
+	require.NoError(t, json.Unmarshal([]byte(body), &lastRun))
+	require.Equal(t, result.Summary.MembershipsRemoved, lastRun.Summary.MembershipsRemoved)
+
+	code, body = doRequest(t, h, http.MethodGet, "/healthz")
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, `"status": "ok"`)
+	require.Contains(t, body, `"config_hash": "sha256:`)
+
+	code, body = doRequest(t, h, http.MethodGet, "/metrics")
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, "qsgpm_runs_total 2\n")
+}
+
+func TestServerReload(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	original, err := os.ReadFile("testdata/config.yaml")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(configPath, original, 0644))
+	s, _ := newTestServer(t, configPath)
+	ctx := context.Background()
+
+	reloaded, err := s.Reload(ctx)
+	require.NoError(t, err)
+	require.False(t, reloaded, "not changed")
+
+	require.NoError(t, os.WriteFile(configPath, []byte("rules: [\n"), 0644))
+	reloaded, err = s.Reload(ctx)
+	require.Error(t, err)
+	require.False(t, reloaded, "invalid config is not loaded")
+	result := s.RunOnce(ctx, qsgpm.RunOption{DryRun: true})
+	require.Empty(t, result.Error)
+	require.Equal(t, 2, result.Summary.GroupsCreated, "the last good config is used")
+
+	changed := strings.Replace(string(original), "  - all\n", "  - all\n  - everyone\n", 1)
+	require.NoError(t, os.WriteFile(configPath, []byte(changed), 0644))
+	reloaded, err = s.Reload(ctx)
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	result = s.RunOnce(ctx, qsgpm.RunOption{DryRun: true})
+	require.Empty(t, result.Error)
+	require.Equal(t, 3, result.Summary.GroupsCreated, "everyone is created")
+}
@@ -0,0 +1,178 @@
+package qsgpm
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/quicksight/types"
+)
+
+// SimulationResult is the result of evaluating the rules for a user.
+type SimulationResult struct {
+	Namespace  string   `json:"namespace"`
+	UserName   string   `json:"user_name"`
+	Groups     []string `json:"groups"`
+	GroupsRule string   `json:"groups_rule,omitempty"`
+	// Exceptions are the active exceptions which add groups.
+	Exceptions []string `json:"exceptions,omitempty"`
+	// Override is the override which adds or removes groups or pins the custom permission, if any.
+	Override             string  `json:"override,omitempty"`
+	CustomPermission     *string `json:"custom_permission"`
+	CustomPermissionRule string  `json:"custom_permission_rule,omitempty"`
+	// Ignored is true if the user is in ignore_users, and the rules are not evaluated.
+	Ignored bool `json:"ignored,omitempty"`
+}
+
+// Simulate evaluates the rules for the users without accessing AWS.
+// The results are sorted by the namespace and the user name.
+func (cfg *Config) Simulate(users []*User) []*SimulationResult {
+	results := make([]*SimulationResult, 0, len(users))
+	for _, user := range users {
+		result := &SimulationResult{
+			Namespace: user.Namespace,
+			UserName:  aws.ToString(user.UserName),
+			Groups:    []string{},
+		}
+		if cfg.ignore.matchUser(user) {
+			result.Ignored = true
+			results = append(results, result)
+			continue
+		}
+		if rule, ok := cfg.MatchGroupRule(user); ok {
+			result.GroupsRule = rule.String()
+		}
+		if groups, ok := cfg.GetGroupNames(user); ok {
+			result.Groups = append(result.Groups, groups...)
+			sort.Strings(result.Groups)
+		}
+		for _, e := range cfg.activeExceptions(user) {
+			result.Exceptions = append(result.Exceptions, e.String())
+		}
+		override, overridden := cfg.matchOverride(user)
+		if overridden {
+			result.Override = override.String()
+		}
+		if overridden && override.CustomPermission != "" {
+			result.CustomPermission = aws.String(override.CustomPermission)
+			result.CustomPermissionRule = override.String()
+		} else if rule, ok := cfg.MatchCustomPermissionRule(user); ok {
+			result.CustomPermission = aws.String(rule.CustomPermission)
+			result.CustomPermissionRule = rule.String()
+		}
+		results = append(results, result)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Namespace != results[j].Namespace {
+			return results[i].Namespace < results[j].Namespace
+		}
+		return results[i].UserName < results[j].UserName
+	})
+	return results
+}
+
+// LoadUsers loads users from a JSON or CSV file.
+// The JSON is a list of objects in the same shape as QuickSight User with Namespace,
+// and the CSV has a header row of the same keys, such as UserName, Email, Role, IdentityType and Namespace.
+// The namespace is default if omitted.
+func LoadUsers(path string) ([]*User, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var users []*User
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		users, err = decodeUsersCSV(f)
+	default:
+		err = json.NewDecoder(f).Decode(&users)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, user := range users {
+		if err := user.normalize(); err != nil {
+			return nil, fmt.Errorf("%s: users[%d]: %w", path, i, err)
+		}
+	}
+	return users, nil
+}
+
+func decodeUsersCSV(r io.Reader) ([]*User, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return []*User{}, nil
+	}
+	header := make([]string, 0, len(records[0]))
+	for _, column := range records[0] {
+		header = append(header, strings.ToLower(strings.ReplaceAll(strings.TrimSpace(column), "_", "")))
+	}
+	users := make([]*User, 0, len(records)-1)
+	for _, record := range records[1:] {
+		user := &User{}
+		for i, value := range record {
+			if i >= len(header) || value == "" {
+				continue
+			}
+			switch header[i] {
+			case "username":
+				user.UserName = aws.String(value)
+			case "email":
+				user.Email = aws.String(value)
+			case "role":
+				user.Role = types.UserRole(value)
+			case "identitytype":
+				user.IdentityType = types.IdentityType(value)
+			case "namespace":
+				user.Namespace = value
+			case "custompermissionsname":
+				user.CustomPermissionsName = aws.String(value)
+			case "arn":
+				user.Arn = aws.String(value)
+			case "principalid":
+				user.PrincipalId = aws.String(value)
+			case "active":
+				active, err := strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("active: %w", err)
+				}
+				user.Active = active
+			}
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// normalize fills the default namespace, and canonicalizes the role and the identity type.
+func (u *User) normalize() error {
+	if u.UserName == nil || *u.UserName == "" {
+		return errors.New("UserName is required")
+	}
+	if u.Namespace == "" {
+		u.Namespace = "default"
+	}
+	for _, role := range u.Role.Values() {
+		if strings.EqualFold(string(role), string(u.Role)) {
+			u.Role = role
+		}
+	}
+	for _, identityType := range u.IdentityType.Values() {
+		if strings.EqualFold(string(identityType), string(u.IdentityType)) {
+			u.IdentityType = identityType
+		}
+	}
+	return nil
+}
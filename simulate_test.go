@@ -0,0 +1,52 @@
+package qsgpm_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigSimulate(t *testing.T) {
+	cfg := qsgpm.NewDefaultConfig()
+	err := cfg.Load("testdata/config.yaml")
+	require.NoError(t, err)
+
+	excpected := []*qsgpm.SimulationResult{
+		{
+			Namespace:            "default",
+			UserName:             "Analyst/piyo@example.com",
+			Groups:               []string{"all", "analysts", "authors"},
+			GroupsRule:           "rules[2]",
+			CustomPermission:     aws.String("analysis"),
+			CustomPermissionRule: "rules[2]",
+		},
+		{
+			Namespace:  "default",
+			UserName:   "Developer/admin@example.com",
+			Groups:     []string{"admins", "all"},
+			GroupsRule: "rules[0]",
+		},
+		{
+			Namespace:            "default",
+			UserName:             "Manager/hoge@example.com",
+			Groups:               []string{"all", "authors", "managers"},
+			GroupsRule:           "rules[1]",
+			CustomPermission:     aws.String("manager"),
+			CustomPermissionRule: "rules[1]",
+		},
+		{
+			Namespace: "default",
+			UserName:  "tora",
+			Groups:    []string{},
+		},
+	}
+	for _, path := range []string{"testdata/users/users.json", "testdata/users/users.csv"} {
+		t.Run(path, func(t *testing.T) {
+			users, err := qsgpm.LoadUsers(path)
+			require.NoError(t, err)
+			require.Equal(t, excpected, cfg.Simulate(users))
+		})
+	}
+}
@@ -0,0 +1,355 @@
+package qsgpm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/quicksight"
+	"github.com/aws/aws-sdk-go-v2/service/quicksight/types"
+	"github.com/mashiike/qsgpm/internal/quicksightx"
+	"gopkg.in/yaml.v3"
+)
+
+// NamespaceState is the current users and groups of a namespace.
+type NamespaceState struct {
+	Users  []*User
+	Groups Groups
+}
+
+// GetNamespaceState gets the current users and groups of the namespace.
+func (svc QuickSightService) GetNamespaceState(ctx context.Context, namespace string) (*NamespaceState, error) {
+	state := &NamespaceState{
+		Users: make([]*User, 0),
+	}
+	p := svc.NewUsersPaginator(namespace)
+	for p.HasMoreUsers() {
+		users, err := p.NextUsers(ctx)
+		if err != nil {
+			return nil, err
+		}
+		state.Users = append(state.Users, users...)
+	}
+	groups, err := svc.GetGroups(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	state.Groups = groups
+	return state, nil
+}
+
+// GetUsersState gets the current state of only the named users, their groups, and the groups expected for them.
+// The groups have only the memberships of the users. Users not in the namespace are skipped.
+func (svc QuickSightService) GetUsersState(ctx context.Context, namespace string, userNames []string, expectGroups func(*User) []string) (*NamespaceState, error) {
+	state := &NamespaceState{
+		Users:  make([]*User, 0, len(userNames)),
+		Groups: newGroups(),
+	}
+	described := make(map[string]bool)
+	for _, userName := range userNames {
+		output, err := svc.client.DescribeUser(ctx, &quicksight.DescribeUserInput{
+			AwsAccountId: aws.String(svc.awsAccountID),
+			Namespace:    aws.String(namespace),
+			UserName:     aws.String(userName),
+		})
+		if err != nil {
+			var notFound *types.ResourceNotFoundException
+			if errors.As(err, &notFound) {
+				log.Printf("[debug] user %s is not in namespace %s", userName, namespace)
+				continue
+			}
+			return nil, err
+		}
+		user := &User{
+			User:      *output.User,
+			Namespace: namespace,
+		}
+		state.Users = append(state.Users, user)
+		p := quicksightx.NewListUserGroupsPaginator(svc.client, &quicksight.ListUserGroupsInput{
+			AwsAccountId: aws.String(svc.awsAccountID),
+			Namespace:    aws.String(namespace),
+			UserName:     aws.String(userName),
+		})
+		for p.HasMorePages() {
+			groupsOutput, err := p.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, group := range groupsOutput.GroupList {
+				log.Printf("[debug] group membership %s in %s exists", userName, *group.GroupName)
+				state.Groups.Add(*group.GroupName, userName)
+				described[*group.GroupName] = true
+			}
+		}
+		for _, groupName := range expectGroups(user) {
+			if described[groupName] {
+				continue
+			}
+			described[groupName] = true
+			_, err := svc.client.DescribeGroup(ctx, &quicksight.DescribeGroupInput{
+				AwsAccountId: aws.String(svc.awsAccountID),
+				Namespace:    aws.String(namespace),
+				GroupName:    aws.String(groupName),
+			})
+			if err != nil {
+				var notFound *types.ResourceNotFoundException
+				if errors.As(err, &notFound) {
+					continue
+				}
+				return nil, err
+			}
+			log.Printf("[debug] group %s exists", groupName)
+			state.Groups.AddGroup(groupName)
+		}
+	}
+	return state, nil
+}
+
+// SnapshotVersion is the version of the snapshot format.
+const SnapshotVersion = 1
+
+// Snapshot is the exported state of QuickSight users, groups, memberships and custom permissions.
+type Snapshot struct {
+	Version    int               `json:"version" yaml:"version"`
+	ExportedAt time.Time         `json:"exported_at" yaml:"exported_at"`
+	Targets    []*TargetSnapshot `json:"targets" yaml:"targets"`
+}
+
+// TargetSnapshot is the exported state of a target. Name is empty if the config has no targets.
+type TargetSnapshot struct {
+	Name       string               `json:"name,omitempty" yaml:"name,omitempty"`
+	AccountID  string               `json:"account_id" yaml:"account_id"`
+	Namespaces []*NamespaceSnapshot `json:"namespaces" yaml:"namespaces"`
+}
+
+type NamespaceSnapshot struct {
+	Name   string           `json:"name" yaml:"name"`
+	Users  []*UserSnapshot  `json:"users" yaml:"users"`
+	Groups []*GroupSnapshot `json:"groups" yaml:"groups"`
+}
+
+type UserSnapshot struct {
+	UserName         string  `json:"user_name" yaml:"user_name"`
+	Email            string  `json:"email,omitempty" yaml:"email,omitempty"`
+	Role             string  `json:"role" yaml:"role"`
+	IdentityType     string  `json:"identity_type" yaml:"identity_type"`
+	CustomPermission *string `json:"custom_permission,omitempty" yaml:"custom_permission,omitempty"`
+	Active           bool    `json:"active" yaml:"active"`
+	Arn              string  `json:"arn,omitempty" yaml:"arn,omitempty"`
+}
+
+type GroupSnapshot struct {
+	Name    string   `json:"name" yaml:"name"`
+	Members []string `json:"members" yaml:"members"`
+}
+
+// Export exports the current state of all managed namespaces of all targets.
+func (app *App) Export(ctx context.Context) (*Snapshot, error) {
+	snapshot := &Snapshot{
+		Version:    SnapshotVersion,
+		ExportedAt: time.Now().UTC(),
+		Targets:    make([]*TargetSnapshot, 0, len(app.targets)),
+	}
+	for _, t := range app.targets {
+		ts := &TargetSnapshot{
+			Name:       t.cfg.String(),
+			AccountID:  t.svc.awsAccountID,
+			Namespaces: make([]*NamespaceSnapshot, 0),
+		}
+		for _, namespace := range t.cfg.GetNamespaces(app.cfg.GetNamespaces()) {
+			log.Printf("[debug] export namespace %s of target %s", namespace, t.cfg)
+			state, err := t.svc.GetNamespaceState(ctx, namespace)
+			if err != nil {
+				if len(app.cfg.Targets) == 0 {
+					return nil, err
+				}
+				return nil, fmt.Errorf("target %s: %w", t.cfg, err)
+			}
+			ts.Namespaces = append(ts.Namespaces, NewNamespaceSnapshot(namespace, state))
+		}
+		snapshot.Targets = append(snapshot.Targets, ts)
+	}
+	return snapshot, nil
+}
+
+// NewNamespaceSnapshot creates the snapshot of the namespace state, sorted by the names.
+func NewNamespaceSnapshot(namespace string, state *NamespaceState) *NamespaceSnapshot {
+	ns := &NamespaceSnapshot{
+		Name:   namespace,
+		Users:  make([]*UserSnapshot, 0, len(state.Users)),
+		Groups: make([]*GroupSnapshot, 0, len(state.Groups)),
+	}
+	for _, user := range state.Users {
+		ns.Users = append(ns.Users, &UserSnapshot{
+			UserName:         aws.ToString(user.UserName),
+			Email:            aws.ToString(user.Email),
+			Role:             string(user.Role),
+			IdentityType:     string(user.IdentityType),
+			CustomPermission: user.CustomPermissionsName,
+			Active:           user.Active,
+			Arn:              aws.ToString(user.Arn),
+		})
+	}
+	sort.Slice(ns.Users, func(i, j int) bool {
+		return ns.Users[i].UserName < ns.Users[j].UserName
+	})
+	for name, group := range state.Groups {
+		members := make([]string, 0, len(group.membership))
+		for member := range group.membership {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		ns.Groups = append(ns.Groups, &GroupSnapshot{
+			Name:    name,
+			Members: members,
+		})
+	}
+	sort.Slice(ns.Groups, func(i, j int) bool {
+		return ns.Groups[i].Name < ns.Groups[j].Name
+	})
+	return ns
+}
+
+// State returns the namespace state of the snapshot.
+func (ns *NamespaceSnapshot) State() *NamespaceState {
+	state := &NamespaceState{
+		Users:  make([]*User, 0, len(ns.Users)),
+		Groups: newGroups(),
+	}
+	for _, u := range ns.Users {
+		user := &User{
+			User: types.User{
+				UserName:              aws.String(u.UserName),
+				Role:                  types.UserRole(u.Role),
+				IdentityType:          types.IdentityType(u.IdentityType),
+				CustomPermissionsName: u.CustomPermission,
+				Active:                u.Active,
+			},
+			Namespace: ns.Name,
+		}
+		if u.Email != "" {
+			user.Email = aws.String(u.Email)
+		}
+		if u.Arn != "" {
+			user.Arn = aws.String(u.Arn)
+		}
+		state.Users = append(state.Users, user)
+	}
+	for _, g := range ns.Groups {
+		state.Groups.AddGroup(g.Name)
+		for _, member := range g.Members {
+			state.Groups.Add(g.Name, member)
+		}
+	}
+	return state
+}
+
+// Users returns all users in the snapshot.
+func (s *Snapshot) Users() []*User {
+	users := make([]*User, 0)
+	for _, t := range s.Targets {
+		for _, ns := range t.Namespaces {
+			users = append(users, ns.State().Users...)
+		}
+	}
+	return users
+}
+
+// target returns the snapshot of the target.
+// If the config has no targets, the only target in the snapshot is used whatever its name is.
+func (s *Snapshot) target(targetCfg *TargetConfig, hasTargets bool) (*TargetSnapshot, bool) {
+	if !hasTargets && len(s.Targets) == 1 {
+		return s.Targets[0], true
+	}
+	for _, t := range s.Targets {
+		if t.Name == targetCfg.String() {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+func (t *TargetSnapshot) namespace(name string) (*NamespaceSnapshot, bool) {
+	for _, ns := range t.Namespaces {
+		if ns.Name == name {
+			return ns, true
+		}
+	}
+	return nil, false
+}
+
+// Encode writes the snapshot in the format, json or yaml.
+func (s *Snapshot) Encode(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(s); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	return fmt.Errorf("unknown format %s", format)
+}
+
+// SnapshotFormat returns the format of the snapshot file by the extension, yaml or json.
+func SnapshotFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	}
+	return "json"
+}
+
+// LoadSnapshot loads a snapshot from a JSON or YAML file.
+func LoadSnapshot(path string) (*Snapshot, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot Snapshot
+	switch SnapshotFormat(path) {
+	case "yaml":
+		err = yaml.Unmarshal(bs, &snapshot)
+	default:
+		err = json.Unmarshal(bs, &snapshot)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if snapshot.Version != SnapshotVersion {
+		return nil, fmt.Errorf("%s: unsupported snapshot version %d, expected %d", path, snapshot.Version, SnapshotVersion)
+	}
+	for _, t := range snapshot.Targets {
+		for _, ns := range t.Namespaces {
+			for i, u := range ns.Users {
+				user := &User{
+					User: types.User{
+						UserName:     aws.String(u.UserName),
+						Role:         types.UserRole(u.Role),
+						IdentityType: types.IdentityType(u.IdentityType),
+					},
+					Namespace: ns.Name,
+				}
+				if err := user.normalize(); err != nil {
+					return nil, fmt.Errorf("%s: namespace %s: users[%d]: %w", path, ns.Name, i, err)
+				}
+				u.Role, u.IdentityType = string(user.Role), string(user.IdentityType)
+			}
+		}
+	}
+	return &snapshot, nil
+}
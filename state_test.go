@@ -0,0 +1,39 @@
+package qsgpm_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSnapshot(t *testing.T) {
+	expected, err := qsgpm.LoadSnapshot("testdata/snapshots/snapshot.json")
+	require.NoError(t, err)
+	require.Len(t, expected.Targets, 1)
+	require.Equal(t, "123456789012", expected.Targets[0].AccountID)
+
+	actual, err := qsgpm.LoadSnapshot("testdata/snapshots/snapshot.yaml")
+	require.NoError(t, err)
+	require.Equal(t, expected, actual, "yaml snapshot is canonicalized as same as json")
+
+	for _, format := range []string{"json", "yaml"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, expected.Encode(&buf, format))
+			path := t.TempDir() + "/snapshot." + format
+			require.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+			actual, err := qsgpm.LoadSnapshot(path)
+			require.NoError(t, err)
+			require.Equal(t, expected, actual)
+		})
+	}
+
+	ns := expected.Targets[0].Namespaces[0]
+	require.Equal(t, ns, qsgpm.NewNamespaceSnapshot(ns.Name, ns.State()))
+
+	_, err = qsgpm.LoadSnapshot("testdata/snapshots/version_unsupported.json")
+	require.EqualError(t, err, "testdata/snapshots/version_unsupported.json: unsupported snapshot version 2, expected 1")
+}
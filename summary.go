@@ -0,0 +1,163 @@
+package qsgpm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SummaryCounts are the counts of what happened in a run.
+type SummaryCounts struct {
+	UsersScanned             int `json:"users_scanned"`
+	UsersMatched             int `json:"users_matched"`
+	UsersUnmatched           int `json:"users_unmatched"`
+	GroupsCreated            int `json:"groups_created"`
+	GroupsDeleted            int `json:"groups_deleted"`
+	MembershipsAdded         int `json:"memberships_added"`
+	MembershipsRemoved       int `json:"memberships_removed"`
+	CustomPermissionsChanged int `json:"custom_permissions_changed"`
+	Errors                   int `json:"errors"`
+	APICalls                 int `json:"api_calls"`
+}
+
+func (c *SummaryCounts) add(other SummaryCounts) {
+	c.UsersScanned += other.UsersScanned
+	c.UsersMatched += other.UsersMatched
+	c.UsersUnmatched += other.UsersUnmatched
+	c.GroupsCreated += other.GroupsCreated
+	c.GroupsDeleted += other.GroupsDeleted
+	c.MembershipsAdded += other.MembershipsAdded
+	c.MembershipsRemoved += other.MembershipsRemoved
+	c.CustomPermissionsChanged += other.CustomPermissionsChanged
+	c.Errors += other.Errors
+	c.APICalls += other.APICalls
+}
+
+// countChange counts the applied change. The changes in dry run are counted as if applied.
+func (c *SummaryCounts) countChange(e *ChangeEvent) {
+	if e.Error != nil {
+		return
+	}
+	switch e.Type {
+	case ChangeUpdateCustomPermission:
+		c.CustomPermissionsChanged++
+	case ChangeCreateGroup:
+		c.GroupsCreated++
+	case ChangeAddMembership:
+		c.MembershipsAdded++
+	case ChangeRemoveMembership:
+		c.MembershipsRemoved++
+	case ChangeDeleteGroup:
+		c.GroupsDeleted++
+	}
+}
+
+func (c SummaryCounts) String() string {
+	return fmt.Sprintf("%d users scanned (%d matched, %d unmatched), %d groups created, %d groups deleted, %d memberships added, %d memberships removed, %d custom permissions changed, %d errors, %d API calls",
+		c.UsersScanned, c.UsersMatched, c.UsersUnmatched,
+		c.GroupsCreated, c.GroupsDeleted, c.MembershipsAdded, c.MembershipsRemoved, c.CustomPermissionsChanged,
+		c.Errors, c.APICalls,
+	)
+}
+
+// RunSummary summarises what happened in a run.
+type RunSummary struct {
+	StartedAt time.Time     `json:"started_at"`
+	Elapsed   time.Duration `json:"-"`
+	DryRun    bool          `json:"dry_run"`
+	SummaryCounts
+	Targets []*TargetSummary `json:"targets"`
+}
+
+// TargetSummary summarises what happened in a target.
+type TargetSummary struct {
+	Name      string `json:"name,omitempty"`
+	AccountID string `json:"account_id"`
+	Error     string `json:"error,omitempty"`
+	SummaryCounts
+
+	// changes are the change events of the target, notified after the run.
+	changes []*ChangeEvent
+}
+
+func (s *RunSummary) MarshalJSON() ([]byte, error) {
+	type summary RunSummary
+	return json.Marshal(struct {
+		*summary
+		ElapsedSeconds float64 `json:"elapsed_seconds"`
+	}{
+		summary:        (*summary)(s),
+		ElapsedSeconds: s.Elapsed.Seconds(),
+	})
+}
+
+func (s *RunSummary) String() string {
+	return fmt.Sprintf("%s in %s", s.SummaryCounts, s.Elapsed.Round(time.Millisecond))
+}
+
+// Markdown renders the summary as a Markdown document.
+func (s *RunSummary) Markdown() string {
+	var b strings.Builder
+	b.WriteString("# qsgpm run summary\n\n")
+	fmt.Fprintf(&b, "- started at: %s\n", s.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "- elapsed: %s\n", s.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(&b, "- dry run: %v\n\n", s.DryRun)
+	b.WriteString("| target | users scanned | users matched | users unmatched | groups created | groups deleted | memberships added | memberships removed | custom permissions changed | errors | API calls |\n")
+	b.WriteString("|---|---:|---:|---:|---:|---:|---:|---:|---:|---:|---:|\n")
+	row := func(name string, c SummaryCounts) {
+		fmt.Fprintf(&b, "| %s | %d | %d | %d | %d | %d | %d | %d | %d | %d | %d |\n",
+			name, c.UsersScanned, c.UsersMatched, c.UsersUnmatched,
+			c.GroupsCreated, c.GroupsDeleted, c.MembershipsAdded, c.MembershipsRemoved, c.CustomPermissionsChanged,
+			c.Errors, c.APICalls,
+		)
+	}
+	for _, t := range s.Targets {
+		name := t.Name
+		if name == "" {
+			name = t.AccountID
+		}
+		row(name, t.SummaryCounts)
+	}
+	row("**total**", s.SummaryCounts)
+	errs := make([]string, 0)
+	for _, t := range s.Targets {
+		if t.Error != "" {
+			name := t.Name
+			if name == "" {
+				name = t.AccountID
+			}
+			errs = append(errs, fmt.Sprintf("- %s: %s\n", name, t.Error))
+		}
+	}
+	if len(errs) > 0 {
+		b.WriteString("\n## Errors\n\n")
+		b.WriteString(strings.Join(errs, ""))
+	}
+	return b.String()
+}
+
+// Encode writes the summary in the format, json or markdown.
+func (s *RunSummary) Encode(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	case "markdown":
+		_, err := io.WriteString(w, s.Markdown())
+		return err
+	}
+	return fmt.Errorf("unknown format %s", format)
+}
+
+// SummaryFormat returns the format of the summary file by the extension, markdown or json.
+func SummaryFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown":
+		return "markdown"
+	}
+	return "json"
+}
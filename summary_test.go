@@ -0,0 +1,122 @@
+package qsgpm_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/quicksight"
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppRunWithSummary(t *testing.T) {
+	app, fake := newTestApp(t, "testdata/config.yaml", "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+	)
+	ctx := context.Background()
+	expected := qsgpm.SummaryCounts{
+		UsersScanned:             4,
+		UsersMatched:             3,
+		UsersUnmatched:           1,
+		GroupsCreated:            2,
+		GroupsDeleted:            1,
+		MembershipsAdded:         5,
+		MembershipsRemoved:       2,
+		CustomPermissionsChanged: 2,
+		// ListUsers, ListGroups and ListGroupMemberships for 4 groups
+		APICalls: 6,
+	}
+
+	summary, err := app.RunWithSummary(ctx, qsgpm.RunOption{DryRun: true})
+	require.NoError(t, err)
+	require.True(t, summary.DryRun)
+	require.Equal(t, expected, summary.SummaryCounts)
+
+	summary, err = app.RunWithSummary(ctx, qsgpm.RunOption{})
+	require.NoError(t, err)
+	expected.APICalls += 12
+	require.Equal(t, expected, summary.SummaryCounts)
+	require.Len(t, summary.Targets, 1)
+	require.Equal(t, expected, summary.Targets[0].SummaryCounts)
+	require.Equal(t, "123456789012", summary.Targets[0].AccountID)
+
+	var buf bytes.Buffer
+	require.NoError(t, summary.Encode(&buf, "json"))
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	require.EqualValues(t, 5, record["memberships_added"])
+	require.Contains(t, record, "elapsed_seconds")
+
+	buf.Reset()
+	require.NoError(t, summary.Encode(&buf, "markdown"))
+	require.Contains(t, buf.String(), "| 123456789012 | 4 | 3 | 1 | 2 | 1 | 5 | 2 | 2 | 0 | 18 |\n")
+	require.Contains(t, buf.String(), "| **total** | 4 | 3 | 1 | 2 | 1 | 5 | 2 | 2 | 0 | 18 |\n")
+
+	app, fake = newTestApp(t, "testdata/config.yaml", "testdata/snapshots/snapshot.json",
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+	)
+	fake.failOn = "UpdateUser"
+	summary, err = app.RunWithSummary(ctx, qsgpm.RunOption{})
+	require.Error(t, err)
+	require.Equal(t, 1, summary.Errors)
+	require.Equal(t, "update custom permission of Analyst/piyo@example.com: UpdateUser failed\n"+
+		"update custom permission of Manager/hoge@example.com: UpdateUser failed", summary.Targets[0].Error)
+	require.Contains(t, summary.Markdown(), "## Errors\n\n- 123456789012: update custom permission of Analyst/piyo@example.com: UpdateUser failed\n")
+	require.Len(t, mutatingCalls(fake.calls), 12, "the other changes are applied after the failed custom permission updates")
+	require.Equal(t, 10, summary.GroupsCreated+summary.GroupsDeleted+summary.MembershipsAdded+summary.MembershipsRemoved)
+}
+
+// accountFailingQuickSight fails to list the users of the account.
+type accountFailingQuickSight struct {
+	*fakeQuickSight
+	accountID string
+}
+
+func (f *accountFailingQuickSight) ListUsers(ctx context.Context, params *quicksight.ListUsersInput, optFns ...func(*quicksight.Options)) (*quicksight.ListUsersOutput, error) {
+	if *params.AwsAccountId == f.accountID {
+		return nil, fmt.Errorf("ListUsers of %s failed", f.accountID)
+	}
+	return f.fakeQuickSight.ListUsers(ctx, params, optFns...)
+}
+
+func TestAppRunWithSummaryTargets(t *testing.T) {
+	base, err := filepath.Abs("testdata/config.yaml")
+	require.NoError(t, err)
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(`include: %q
+targets:
+  - name: broken
+    account_id: "210987654321"
+  - name: production
+    account_id: "123456789012"
+`, base)), 0644))
+	cfg := qsgpm.NewDefaultConfig()
+	require.NoError(t, cfg.Load(configPath))
+	snapshot, err := qsgpm.LoadSnapshot("testdata/snapshots/snapshot.json")
+	require.NoError(t, err)
+	fake := newFakeQuickSight(snapshot)
+	app, err := qsgpm.New(context.Background(), cfg,
+		qsgpm.WithQuickSightClient(&accountFailingQuickSight{fakeQuickSight: fake, accountID: "210987654321"}, ""),
+		qsgpm.WithChangeLogger(func(e *qsgpm.ChangeEvent) {}),
+	)
+	require.NoError(t, err)
+	defer app.Close()
+
+	summary, err := app.RunWithSummary(context.Background(), qsgpm.RunOption{})
+	require.EqualError(t, err, "target broken: ListUsers of 210987654321 failed")
+	require.Len(t, summary.Targets, 2)
+	require.Equal(t, "broken", summary.Targets[0].Name)
+	require.Equal(t, "ListUsers of 210987654321 failed", summary.Targets[0].Error)
+	require.Equal(t, qsgpm.SummaryCounts{Errors: 1, APICalls: 1}, summary.Targets[0].SummaryCounts, "nothing is applied to the failed target")
+	require.Equal(t, "production", summary.Targets[1].Name)
+	require.Empty(t, summary.Targets[1].Error)
+	require.Equal(t, 2, summary.Targets[1].GroupsCreated, "the other target is applied")
+	require.Len(t, mutatingCalls(fake.calls), 12)
+	require.Equal(t, 1, summary.Errors)
+	require.Equal(t, 2, summary.GroupsCreated)
+}
@@ -0,0 +1,368 @@
+package qsgpm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	gc "github.com/kayac/go-config"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// ValidationError is a problem of the config, with the position in the file if known.
+type ValidationError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.File == "" {
+		return e.Message
+	}
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+}
+
+// ValidateConfig validates the config files without accessing AWS.
+// It reports all problems found as joined *ValidationError, including unknown keys.
+func ValidateConfig(paths ...string) error {
+	if len(paths) == 0 {
+		return errors.New("no config")
+	}
+	sources, err := NewConfigSources(paths...)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	v := &validator{
+		schema:  GenerateSchema(),
+		visited: make(map[string]bool),
+	}
+	for _, src := range sources {
+		v.readFile(ctx, src)
+	}
+	if len(v.errs) > 0 {
+		return errors.Join(v.errs...)
+	}
+	cfg := NewDefaultConfig()
+	if err := cfg.LoadSources(ctx, sources...); err != nil {
+		for _, e := range flattenErrors(err) {
+			v.errs = append(v.errs, v.locate(e))
+		}
+	}
+	return errors.Join(v.errs...)
+}
+
+type validatedFile struct {
+	path string
+	root *yamlv3.Node
+}
+
+type validator struct {
+	schema  *Schema
+	files   []*validatedFile
+	visited map[string]bool
+	errs    []error
+}
+
+func (v *validator) readFile(ctx context.Context, src ConfigSource) {
+	path := src.String()
+	key, err := configSourceKey(src)
+	if err != nil {
+		v.errs = append(v.errs, &ValidationError{File: path, Message: err.Error()})
+		return
+	}
+	if v.visited[key] {
+		return
+	}
+	v.visited[key] = true
+	bs, err := src.Read(ctx)
+	if err == nil {
+		bs, err = gc.ReadWithEnvBytes(bs)
+	}
+	if err != nil {
+		v.errs = append(v.errs, &ValidationError{File: path, Message: err.Error()})
+		return
+	}
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(bs, &doc); err != nil {
+		v.errs = append(v.errs, &ValidationError{File: path, Message: err.Error()})
+		return
+	}
+	if len(doc.Content) == 0 {
+		return
+	}
+	root := doc.Content[0]
+	for _, key := range []string{layerKeyInclude, layerKeyExtends} {
+		node := mappingValue(root, key)
+		if node == nil {
+			continue
+		}
+		includes := []*yamlv3.Node{node}
+		if node.Kind == yamlv3.SequenceNode {
+			includes = node.Content
+		}
+		for _, include := range includes {
+			if include.Kind != yamlv3.ScalarNode {
+				continue
+			}
+			includeSrc, err := src.Include(include.Value)
+			if err != nil {
+				v.errs = append(v.errs, &ValidationError{File: path, Line: include.Line, Column: include.Column, Message: err.Error()})
+				continue
+			}
+			v.readFile(ctx, includeSrc)
+		}
+	}
+	v.files = append(v.files, &validatedFile{
+		path: path,
+		root: root,
+	})
+	v.walk(path, root, v.schema, "")
+}
+
+// walk validates the node by the schema.
+func (v *validator) walk(file string, node *yamlv3.Node, s *Schema, path string) {
+	if node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yamlv3.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+	errorf := func(n *yamlv3.Node, format string, args ...interface{}) {
+		v.errs = append(v.errs, &ValidationError{
+			File:    file,
+			Line:    n.Line,
+			Column:  n.Column,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+	if len(s.OneOf) > 0 {
+		for _, alt := range s.OneOf {
+			sub := &validator{schema: v.schema}
+			sub.walk(file, node, alt, path)
+			if len(sub.errs) == 0 {
+				return
+			}
+		}
+		errorf(node, "%s: unexpected value", displayPath(path))
+		return
+	}
+	switch s.Type {
+	case "object":
+		if node.Kind != yamlv3.MappingNode {
+			errorf(node, "%s: expected a mapping", displayPath(path))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if p, ok := s.Properties[key.Value]; ok {
+				v.walk(file, value, p, joinPath(path, key.Value))
+				continue
+			}
+			if s.AdditionalProperties != nil {
+				v.walk(file, value, s.AdditionalProperties, fmt.Sprintf("%s[%s]", path, key.Value))
+				continue
+			}
+			if suggestion := suggestKey(key.Value, s.Properties); suggestion != "" {
+				errorf(key, "%s: unknown key %q, did you mean %q?", displayPath(path), key.Value, suggestion)
+				continue
+			}
+			errorf(key, "%s: unknown key %q", displayPath(path), key.Value)
+		}
+	case "array":
+		if node.Kind != yamlv3.SequenceNode {
+			errorf(node, "%s: expected a list", displayPath(path))
+			return
+		}
+		for i, item := range node.Content {
+			v.walk(file, item, s.Items, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case "boolean":
+		if node.Kind != yamlv3.ScalarNode || node.Tag != "!!bool" {
+			errorf(node, "%s: expected a boolean", displayPath(path))
+		}
+	case "integer":
+		if node.Kind != yamlv3.ScalarNode || node.Tag != "!!int" {
+			errorf(node, "%s: expected an integer", displayPath(path))
+		}
+	default:
+		if node.Kind != yamlv3.ScalarNode {
+			errorf(node, "%s: expected a string", displayPath(path))
+			return
+		}
+		if len(s.Enum) == 0 {
+			return
+		}
+		for _, e := range s.Enum {
+			if node.Value == e {
+				return
+			}
+		}
+		errorf(node, "%s: %q is not one of %v", displayPath(path), node.Value, s.Enum)
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
+}
+
+// suggestKey returns the known key closest to the unknown key, if it is close enough.
+func suggestKey(key string, properties map[string]*Schema) string {
+	best, bestDistance := "", 3
+	for p := range properties {
+		if d := editDistance(key, p); d < bestDistance || (d == bestDistance && best != "" && p < best) {
+			best, bestDistance = p, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// flattenErrors returns the errors joined by errors.Join.
+func flattenErrors(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := make([]error, 0)
+		for _, e := range joined.Unwrap() {
+			errs = append(errs, flattenErrors(e)...)
+		}
+		return errs
+	}
+	return []error{err}
+}
+
+var pathSegmentPattern = regexp.MustCompile(`^([a-z_]+)(?:\[(.+)\])?$`)
+
+// locate finds the position of the error in the config files by the path of the error.
+// Since rules are merged across config files, rules are located by the position only in a single file.
+func (v *validator) locate(err error) error {
+	segments := make([]string, 0)
+	for e := err; ; {
+		var ce *configError
+		if !errors.As(e, &ce) {
+			break
+		}
+		segments = append(segments, ce.path)
+		e = ce.err
+	}
+	verr := &ValidationError{
+		Message: err.Error(),
+	}
+	if len(segments) == 0 {
+		return verr
+	}
+	for i := len(v.files) - 1; i >= 0; i-- {
+		f := v.files[i]
+		if node := lookupNode(f.root, segments, len(v.files) == 1); node != nil {
+			verr.File = f.path
+			verr.Line = node.Line
+			verr.Column = node.Column
+			return verr
+		}
+	}
+	return verr
+}
+
+func lookupNode(node *yamlv3.Node, segments []string, rulesByIndex bool) *yamlv3.Node {
+	for _, segment := range segments {
+		m := pathSegmentPattern.FindStringSubmatch(segment)
+		if m == nil {
+			return nil
+		}
+		key, index := m[1], m[2]
+		node = mappingValue(node, key)
+		if node == nil {
+			return nil
+		}
+		if index == "" {
+			continue
+		}
+		switch node.Kind {
+		case yamlv3.MappingNode:
+			node = mappingValue(node, index)
+		case yamlv3.SequenceNode:
+			node = sequenceItem(node, key, index, rulesByIndex)
+		default:
+			return nil
+		}
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+func sequenceItem(node *yamlv3.Node, key string, index string, rulesByIndex bool) *yamlv3.Node {
+	if i, err := strconv.Atoi(index); err == nil {
+		if key == "rules" && !rulesByIndex {
+			return nil
+		}
+		if i < 0 || i >= len(node.Content) {
+			return nil
+		}
+		return node.Content[i]
+	}
+	for _, item := range node.Content {
+		if id := mappingValue(item, "id"); id != nil && id.Value == index {
+			return item
+		}
+	}
+	return nil
+}
+
+func mappingValue(node *yamlv3.Node, key string) *yamlv3.Node {
+	if node == nil || node.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
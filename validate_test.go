@@ -0,0 +1,55 @@
+package qsgpm_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/mashiike/qsgpm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateConfig(t *testing.T) {
+	cases := []struct {
+		paths     []string
+		excpected string
+	}{
+		{
+			paths: []string{"testdata/config.yaml"},
+		},
+		{
+			paths: []string{"testdata/layers/staging.yaml", "testdata/layers/override_by_id.yaml"},
+		},
+		{
+			paths: []string{"testdata/validate_invalid.yaml"},
+			excpected: "testdata/validate_invalid.yaml:5:3: user: unknown key \"rol\", did you mean \"role\"?\n" +
+				"testdata/validate_invalid.yaml:11:13: rules[0].groups: expected a list\n" +
+				"testdata/validate_invalid.yaml:12:5: rules[0]: unknown key \"custom_permision\", did you mean \"custom_permission\"?\n" +
+				"testdata/validate_invalid.yaml:14:14: create_only: expected a boolean",
+		},
+		{
+			paths: []string{"testdata/validate_restrict.yaml"},
+			excpected: "testdata/validate_restrict.yaml:6:7: rules[a]: user: given Role: Auther is not one of ADMIN, AUTHOR, READER, RESTRICTED_AUTHOR or RESTRICTED_READER\n" +
+				"testdata/validate_restrict.yaml:9:7: rules[1]: user: given IdentityType: hoge is not one of IAM or QUICKSIGHT\n" +
+				"testdata/validate_restrict.yaml:11:5: targets[0]: account_id 12 is not a 12-digit AWS account ID",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.paths[0], func(t *testing.T) {
+			err := qsgpm.ValidateConfig(c.paths...)
+			if c.excpected == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, c.excpected)
+		})
+	}
+}
+
+func TestSchemaUpToDate(t *testing.T) {
+	expected, err := json.MarshalIndent(qsgpm.GenerateSchema(), "", "  ")
+	require.NoError(t, err)
+	actual, err := os.ReadFile("schema.json")
+	require.NoError(t, err)
+	require.JSONEq(t, string(expected), string(actual), "schema.json is outdated, run go generate")
+}